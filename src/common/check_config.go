@@ -0,0 +1,54 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"fmt"
+	"os"
+
+	"common/config"
+)
+
+// CheckConfig checks the configuration file located at a given path, prints
+// every problem found in it on the standard error, and returns the code the
+// program should exit with. If requireFeeds is true, a missing "feeds" section
+// is also reported as a problem.
+// Returns 0 if no problem was found, 1 otherwise.
+func CheckConfig(filePath string, requireFeeds bool) int {
+	cfg, problems, err := config.Check(filePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't read config: %s\n", err.Error())
+		return 1
+	}
+
+	if cfg != nil && requireFeeds && cfg.FeedsConfig == nil {
+		problems = append(problems, config.Problem{
+			Message: "No 'feeds' configuration found, please provide one",
+		})
+	}
+
+	if len(problems) == 0 {
+		fmt.Printf("%s: configuration is valid\n", filePath)
+		return 0
+	}
+
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", filePath, p.String())
+	}
+	fmt.Fprintf(os.Stderr, "%d problem(s) found\n", len(problems))
+
+	return 1
+}
@@ -0,0 +1,650 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// yamlErrorRegexp matches the errors generated by the YAML decoder, which
+	// start with the number of the line the error was found at.
+	yamlErrorRegexp = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+	// selectorAttributeRegexp matches a CSS selector followed by "@" and the
+	// name of an attribute.
+	selectorAttributeRegexp = regexp.MustCompile(`^(.+)@([A-Za-z_:][-A-Za-z0-9_:.]*)$`)
+	// layoutPatternRegexp matches the {PATTERN}s in a date layout.
+	layoutPatternRegexp = regexp.MustCompile(`\{([^{}]*)\}`)
+	// keyLineRegexp matches a line defining a key in a YAML mapping, possibly
+	// as the first key of an item in a list.
+	keyLineRegexp = regexp.MustCompile(`^(\s*)(- +)?([A-Za-z0-9_]+)\s*:`)
+)
+
+// Problem represents an issue found in the configuration file.
+type Problem struct {
+	// Line is the number of the line in the configuration file the problem was
+	// found at, or 0 if it can't be related to a specific line.
+	Line    int
+	Message string
+}
+
+// String implements fmt.Stringer.String
+func (p Problem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+	}
+
+	return p.Message
+}
+
+// Problems represents all the issues found in the configuration file. It
+// implements error so it can be returned by Load.
+type Problems []Problem
+
+// Error implements error.Error
+func (p Problems) Error() string {
+	messages := make([]string, len(p))
+	for i, problem := range p {
+		messages[i] = problem.String()
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// add appends a new problem to the list.
+func (p *Problems) add(line int, format string, args ...interface{}) {
+	*p = append(*p, Problem{Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+// Check reads the configuration file located at a given path, loads it into a
+// Config instance and looks for problems in it, i.e. unknown or duplicate keys,
+// values of the wrong type, missing required values, duplicate website
+// identifiers, invalid URLs, regexps and CSS selectors, unknown {PATTERN}s in
+// date formats, and unsupported database drivers and feed types. Problems are
+// sorted by line.
+// If the file isn't valid YAML, the configuration can't be loaded, and the
+// syntax error is returned as the only problem with a nil Config.
+// Returns an error if there was an issue reading the file.
+func Check(filePath string) (cfg *Config, problems Problems, err error) {
+	// Reads the configuration file.
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return
+	}
+
+	// Parse the configuration file into the Config instance. The strict mode
+	// reports unknown and duplicate keys. Decoding errors (e.g. a value of the
+	// wrong type) don't stop the decoding, and are all reported at once in a
+	// yaml.TypeError.
+	cfg = new(Config)
+	if decodeErr := yaml.UnmarshalStrict(content, cfg); decodeErr != nil {
+		typeErr, ok := decodeErr.(*yaml.TypeError)
+		if !ok {
+			cfg = nil
+			problems = append(problems, yamlProblem(decodeErr.Error()))
+			return
+		}
+
+		for _, msg := range typeErr.Errors {
+			problems = append(problems, yamlProblem(msg))
+		}
+	}
+
+	problems = append(problems, checkConfig(cfg, newLineLocator(content))...)
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Line < problems[j].Line
+	})
+
+	return
+}
+
+// yamlProblem converts an error message generated by the YAML decoder into a
+// Problem, extracting the line number from it if there's one.
+func yamlProblem(msg string) Problem {
+	if m := yamlErrorRegexp.FindStringSubmatch(msg); m != nil {
+		// The regexp ensures the line number only contains digits.
+		line, _ := strconv.Atoi(m[1])
+		return Problem{Line: line, Message: m[2]}
+	}
+
+	return Problem{Message: strings.TrimPrefix(msg, "yaml: ")}
+}
+
+// checkConfig looks for problems in the values of a decoded configuration, and
+// replaces the {PATTERN}s in the websites' date formats. The given lineLocator
+// is used to find the line each problem is located at.
+func checkConfig(cfg *Config, locator *lineLocator) (problems Problems) {
+	identifiers := make(map[string]int)
+
+	for i, w := range cfg.Websites {
+		if w == nil {
+			problems.add(locator.website(i), "Empty website")
+			continue
+		}
+
+		line := func(keys ...string) int {
+			return locator.websiteKey(i, keys...)
+		}
+
+		// Check the website's identifier, which must be unique.
+		if len(w.Identifier) == 0 {
+			problems.add(locator.website(i), "Website #%d has no identifier", i+1)
+		} else if first, exists := identifiers[w.Identifier]; exists {
+			problems.add(
+				line("identifier"), "Duplicate website identifier %s (already used at line %d)",
+				w.Identifier, first,
+			)
+		} else {
+			identifiers[w.Identifier] = line("identifier")
+		}
+
+		// Check the URL provided for the website.
+		if len(w.StartPoint) == 0 {
+			problems.add(locator.website(i), "Missing start point for %s", w.Identifier)
+		} else if u, err := url.Parse(w.StartPoint); err != nil {
+			problems.add(
+				line("start_point"), "Start point for %s isn't a valid URL: %s",
+				w.Identifier, err.Error(),
+			)
+		} else if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			problems.add(
+				line("start_point"), "Start point for %s must be an absolute HTTP(S) URL",
+				w.Identifier,
+			)
+		}
+
+		// Check the CSS selectors. The title, content and date ones are required.
+		selectors := []struct {
+			name     string
+			value    string
+			required bool
+		}{
+			{"title", w.Selectors.Title, true},
+			{"description", w.Selectors.Description, false},
+			{"content", w.Selectors.Content, true},
+			{"author", w.Selectors.Author, false},
+			{"date", w.Selectors.Date, true},
+			{"thumbnail", w.Selectors.Thumbnail, false},
+		}
+		for _, s := range selectors {
+			if len(s.value) == 0 {
+				if s.required {
+					problems.add(
+						line("selectors"), "Missing %s selector for %s", s.name, w.Identifier,
+					)
+				}
+				continue
+			}
+
+			// Only the date can be read from an attribute.
+			value := s.value
+			if s.name == "date" {
+				value, _ = SplitSelectorAttribute(value)
+			}
+			if _, err := cascadia.Compile(value); err != nil {
+				problems.add(
+					line("selectors", s.name), "Invalid %s selector for %s: %s",
+					s.name, w.Identifier, err.Error(),
+				)
+			}
+		}
+
+		// Check the date URL pattern, which must capture at least the year and
+		// month.
+		if len(w.DateURLPattern) > 0 {
+			re, err := regexp.Compile(w.DateURLPattern)
+			if err != nil {
+				problems.add(
+					line("date_url_pattern"), "Invalid date URL pattern for %s: %s",
+					w.Identifier, err.Error(),
+				)
+			} else {
+				groups := make(map[string]bool)
+				for _, name := range re.SubexpNames() {
+					groups[name] = true
+				}
+				for _, group := range []string{"year", "month"} {
+					if !groups[group] {
+						problems.add(
+							line("date_url_pattern"), "Missing %s group in date URL pattern for %s",
+							group, w.Identifier,
+						)
+					}
+				}
+				w.DateURLRegexp = re
+			}
+		}
+
+		// Load the time zone the website's dates are written in.
+		w.Location = time.UTC
+		if len(w.Timezone) > 0 {
+			loc, err := time.LoadLocation(w.Timezone)
+			if err != nil {
+				problems.add(
+					line("timezone"), "Invalid time zone for %s: %s",
+					w.Identifier, err.Error(),
+				)
+			} else {
+				w.Location = loc
+			}
+		}
+
+		// Check the patterns used in the date formats before replacing them.
+		// Dates read from an attribute or a URL are usually written in a
+		// standard format, which is detected without needing a date format.
+		formats := w.DateFormats
+		if len(w.DateFormat) > 0 {
+			formats = append(stringList{w.DateFormat}, formats...)
+		}
+		_, dateAttr := SplitSelectorAttribute(w.Selectors.Date)
+		if len(formats) == 0 && len(dateAttr) == 0 && len(w.DateURLPattern) == 0 {
+			problems.add(locator.website(i), "Missing date format for %s", w.Identifier)
+		}
+		for j := range formats {
+			// Locate the format, which is either date_format or an item of the
+			// date_formats list.
+			formatLine := line("date_format")
+			if k := j - (len(formats) - len(w.DateFormats)); k >= 0 {
+				formatLine = locator.websiteListItem(i, k, "date_formats")
+			}
+			for _, m := range layoutPatternRegexp.FindAllStringSubmatch(formats[j], -1) {
+				if _, known := patterns[m[1]]; !known {
+					problems.add(
+						formatLine, "Unknown pattern %s in date format for %s",
+						m[0], w.Identifier,
+					)
+				}
+			}
+			replaceLayoutPatterns(&formats[j])
+		}
+		replaceLayoutPatterns(&w.DateFormat)
+		w.DateFormats = formats
+
+		// Check the templates of the website's archive pages.
+		if w.Backfill != nil {
+			if len(w.Backfill.ArchiveURLs) == 0 {
+				problems.add(line("backfill"), "Missing archive URL for %s", w.Identifier)
+			}
+			for _, template := range w.Backfill.ArchiveURLs {
+				for _, m := range layoutPatternRegexp.FindAllStringSubmatch(template, -1) {
+					if _, known := patterns[m[1]]; !known {
+						problems.add(
+							line("backfill"), "Unknown pattern %s in archive URL for %s",
+							m[0], w.Identifier,
+						)
+					}
+				}
+				u, err := url.Parse(FormatLayoutPatterns(template, time.Now()))
+				if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+					problems.add(
+						line("backfill"), "Invalid archive URL %s for %s", template, w.Identifier,
+					)
+				}
+			}
+		}
+
+		// Check that a renderer is configured if the website needs one.
+		if w.Render && cfg.Crawler.Renderer == nil {
+			problems.add(
+				line("render"), "%s requires rendering but no renderer is configured",
+				w.Identifier,
+			)
+		}
+		// Pages are loaded by the browser, which can't be configured for each
+		// website, so the transport settings it would ignore are rejected. The
+		// timeouts only apply to robots.txt files, which are still fetched
+		// without the browser.
+		if transport := cfg.Crawler.Transport.Merge(w.Transport); w.Render && transport != nil {
+			if len(transport.Proxy) > 0 || len(transport.CABundle) > 0 ||
+				len(transport.ClientCert) > 0 || transport.InsecureSkipVerify {
+				problems.add(
+					line("render"), "%s requires rendering, which doesn't support proxy and TLS transport settings",
+					w.Identifier,
+				)
+			}
+		}
+
+		problems = append(problems, checkTransport(w.Transport, func(key string) int {
+			return line("transport", key)
+		})...)
+
+		// Check that the login form can be submitted.
+		if w.Session != nil && w.Session.Login != nil {
+			login := w.Session.Login
+			if u, err := url.Parse(login.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+				problems.add(
+					line("session", "login", "url"), "Login URL for %s must be an absolute http(s) URL",
+					w.Identifier,
+				)
+			}
+			if method := strings.ToUpper(login.Method); len(method) > 0 && method != "GET" && method != "POST" {
+				problems.add(
+					line("session", "login", "method"), "Unsupported login method %s for %s (must be GET or POST)",
+					login.Method, w.Identifier,
+				)
+			}
+		}
+
+		if w.WARC != nil && len(w.WARC.Directory) == 0 {
+			problems.add(line("warc"), "Missing WARC directory for %s", w.Identifier)
+		}
+
+		// Report the regexps that couldn't be parsed when decoding the filters.
+		if w.Filters != nil {
+			for _, name := range []string{"restrict", "exclude", "article_pattern", "listing_pattern"} {
+				if err, ok := w.Filters.errs[name]; ok {
+					problems.add(
+						line("filters", name), "Invalid %s filter for %s: %s",
+						name, w.Identifier, err.Error(),
+					)
+				}
+			}
+		}
+	}
+
+	// Check the transport settings shared by all websites.
+	problems = append(problems, checkTransport(cfg.Crawler.Transport, func(key string) int {
+		return locator.key("crawler", "transport", key)
+	})...)
+
+	// Check the visited sets' settings.
+	if v := cfg.Crawler.VisitedSet; v != nil {
+		switch v.Type {
+		case "", "memory":
+		case "bloom":
+			if len(v.Dir) == 0 {
+				problems.add(locator.key("crawler", "visited_set"), "Missing directory for the Bloom filters")
+			}
+		default:
+			problems.add(
+				locator.key("crawler", "visited_set", "type"),
+				"Unsupported visited set type %s (must be either memory or bloom)", v.Type,
+			)
+		}
+		if v.FalsePositiveRate < 0 || v.FalsePositiveRate >= 1 {
+			problems.add(
+				locator.key("crawler", "visited_set", "false_positive_rate"),
+				"False positive rate must be between 0 and 1",
+			)
+		}
+	}
+
+	// Check if the database driver is supported.
+	if cfg.Database.DriverName != "postgres" && cfg.Database.DriverName != "sqlite3" {
+		problems.add(
+			locator.key("database", "driver"), "Unsupported database driver %s",
+			cfg.Database.DriverName,
+		)
+	}
+
+	// Check the logging configuration.
+	if f := cfg.Logging.Format; len(f) > 0 && f != "text" && f != "json" {
+		problems.add(locator.key("logging", "format"), "Unsupported log format %s", f)
+	}
+	if len(cfg.Logging.Level) > 0 {
+		if _, err := logrus.ParseLevel(cfg.Logging.Level); err != nil {
+			problems.add(locator.key("logging", "level"), "Invalid log level %s", cfg.Logging.Level)
+		}
+	}
+	for component, level := range cfg.Logging.Components {
+		known := false
+		for _, c := range LogComponents {
+			known = known || c == component
+		}
+		if !known {
+			problems.add(
+				locator.key("logging", "components", component),
+				"Unknown log component %s (must be one of: %s)",
+				component, strings.Join(LogComponents, ", "),
+			)
+		} else if _, err := logrus.ParseLevel(level); err != nil {
+			problems.add(
+				locator.key("logging", "components", component),
+				"Invalid log level %s for component %s", level, component,
+			)
+		}
+	}
+	if cfg.Logging.File != nil && len(cfg.Logging.File.Path) == 0 {
+		problems.add(locator.key("logging", "file"), "Missing path for the log file")
+	}
+
+	// Report the feed type if it couldn't be recognised when decoding it.
+	if cfg.FeedsConfig != nil && cfg.FeedsConfig.typeErr != nil {
+		problems.add(locator.key("feeds", "type"), "%s", cfg.FeedsConfig.typeErr.Error())
+	}
+
+	// Check that aggregate feeds only gather known websites, and that their
+	// names don't clash with other routes of the feed generator.
+	if cfg.FeedsConfig != nil {
+		for name, websites := range cfg.FeedsConfig.Aggregates {
+			line := locator.key("feeds", "aggregates", name)
+			if _, exists := identifiers[name]; exists || name == "api" || name == "metrics" {
+				problems.add(line, "Aggregate feed name %s is already used", name)
+			}
+			if len(websites) == 0 {
+				problems.add(line, "Missing websites for aggregate feed %s", name)
+			}
+			for _, w := range websites {
+				if _, exists := identifiers[w]; !exists {
+					problems.add(line, "Unknown website %s in aggregate feed %s", w, name)
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// checkTransport looks for problems in a transport configuration, which can be
+// nil. The given function is used to find the line each setting is located at.
+func checkTransport(t *TransportConfig, line func(key string) int) (problems Problems) {
+	if t == nil {
+		return
+	}
+
+	if len(t.Proxy) > 0 {
+		u, err := url.Parse(t.Proxy)
+		if err != nil {
+			problems.add(line("proxy"), "Invalid proxy URL: %s", err.Error())
+		} else if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" {
+			problems.add(
+				line("proxy"), "Unsupported proxy scheme %s (must be http, https or socks5)",
+				u.Scheme,
+			)
+		}
+	}
+
+	if (len(t.ClientCert) > 0) != (len(t.ClientKey) > 0) {
+		problems.add(line("client_cert"), "Client certificate and key must be provided together")
+	}
+
+	return
+}
+
+// lineLocator finds the lines at which keys are defined in a YAML configuration
+// file. The YAML decoder doesn't expose the position of the values it decodes,
+// so this relies on the file's layout instead: it expects each key to be on its
+// own line, as it is in the sample configuration file. If a key can't be found,
+// the line of its closest parent is used instead.
+type lineLocator struct {
+	lines []string
+	// websites contains the index of the first line of each item of the
+	// "websites" list.
+	websites []int
+	// websitesEnd is the index of the line following the "websites" list.
+	websitesEnd int
+}
+
+// newLineLocator instantiates a lineLocator for the given file content, and
+// looks for the items of the "websites" list in it.
+func newLineLocator(content []byte) *lineLocator {
+	l := &lineLocator{lines: strings.Split(string(content), "\n")}
+	l.websitesEnd = len(l.lines)
+
+	start := l.find(0, len(l.lines), "websites")
+	if start < 0 {
+		return l
+	}
+
+	// Items are the lines starting with a dash at the list's indentation, which
+	// is the one of the first item.
+	itemIndent := -1
+	for i := start + 1; i < len(l.lines); i++ {
+		m := keyLineRegexp.FindStringSubmatch(l.lines[i])
+		if m == nil {
+			continue
+		}
+
+		indent := len(m[1])
+		if indent == 0 && len(m[2]) == 0 {
+			// Next top-level key, the list is over.
+			l.websitesEnd = i
+			break
+		}
+		if len(m[2]) > 0 && (itemIndent < 0 || indent == itemIndent) {
+			itemIndent = indent
+			l.websites = append(l.websites, i)
+		}
+	}
+
+	return l
+}
+
+// find looks for the first line defining the given key between the from-th
+// (included) and the to-th (excluded) lines.
+// Returns the index of the line, or -1 if no line matches.
+func (l *lineLocator) find(from int, to int, key string) int {
+	for i := from; i < to; i++ {
+		m := keyLineRegexp.FindStringSubmatch(l.lines[i])
+		if m != nil && m[3] == key {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// findPath looks for the line defining the last key of a path of nested keys
+// between the from-th (included) and the to-th (excluded) lines.
+// Returns the index of the line defining the deepest key that could be found,
+// or from if none could be found.
+func (l *lineLocator) findPath(from int, to int, keys ...string) int {
+	found := from
+	for _, key := range keys {
+		i := l.find(found, to, key)
+		if i < 0 {
+			break
+		}
+		found = i
+	}
+
+	return found
+}
+
+// key returns the number of the line defining the last key of a path of nested
+// keys starting at the root of the file.
+// Returns 0 if the first key couldn't be found.
+func (l *lineLocator) key(keys ...string) int {
+	start := l.find(0, len(l.lines), keys[0])
+	if start < 0 {
+		return 0
+	}
+
+	return l.findPath(start, len(l.lines), keys[1:]...) + 1
+}
+
+// website returns the number of the line the i-th website starts at.
+// Returns 0 if the website couldn't be found.
+func (l *lineLocator) website(i int) int {
+	if i >= len(l.websites) {
+		return 0
+	}
+
+	return l.websites[i] + 1
+}
+
+// websiteKey returns the number of the line defining the last key of a path of
+// nested keys in the i-th website.
+// Returns 0 if the website couldn't be found.
+func (l *lineLocator) websiteKey(i int, keys ...string) int {
+	if i >= len(l.websites) {
+		return 0
+	}
+
+	end := l.websitesEnd
+	if i+1 < len(l.websites) {
+		end = l.websites[i+1]
+	}
+
+	return l.findPath(l.websites[i], end, keys...) + 1
+}
+
+// websiteListItem returns the number of the line the n-th item of a list is
+// defined at, the list being the value of the last key of a path of nested keys
+// in the i-th website. If the list isn't written as a block of items (e.g. it
+// is a single string), the line defining the key is returned instead.
+// Returns 0 if the website couldn't be found.
+func (l *lineLocator) websiteListItem(i int, n int, keys ...string) int {
+	keyLine := l.websiteKey(i, keys...)
+	if keyLine == 0 {
+		return 0
+	}
+
+	m := keyLineRegexp.FindStringSubmatch(l.lines[keyLine-1])
+	if m == nil || m[3] != keys[len(keys)-1] {
+		return keyLine
+	}
+	keyIndent := len(m[1]) + len(m[2])
+
+	// Items are the lines starting with a dash at the list's indentation, which
+	// is the one of the first item, and can be the key's one. The list is over
+	// at the first other line that isn't indented more than the key.
+	itemIndent := -1
+	for j := keyLine; j < len(l.lines); j++ {
+		line := strings.TrimRight(l.lines[j], " \t")
+		trimmed := strings.TrimLeft(line, " ")
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		indent := len(line) - len(trimmed)
+		if strings.HasPrefix(trimmed, "-") && (indent == itemIndent || (itemIndent < 0 && indent >= keyIndent)) {
+			itemIndent = indent
+			if n == 0 {
+				return j + 1
+			}
+			n--
+			continue
+		}
+		if indent <= keyIndent {
+			break
+		}
+	}
+
+	return keyLine
+}
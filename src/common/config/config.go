@@ -17,12 +17,8 @@ package config
 
 import (
 	"fmt"
-	"io/ioutil"
-	"net/url"
 	"regexp"
 	"time"
-
-	"gopkg.in/yaml.v2"
 )
 
 // FeedType represents the type of the feed: either RSS or Atom.
@@ -40,26 +36,206 @@ type Config struct {
 	Websites    []*Website     `yaml:"websites"`
 	Database    DatabaseConfig `yaml:"database"`
 	FeedsConfig *FeedsConfig   `yaml:"feeds,omitempty"`
+	Logging     LoggingConfig  `yaml:"logging,omitempty"`
 }
 
 // CrawlerConfig represents the specific configuration for the crawler, which
 // will be applied across all instances.
+// RecordDir, ReplayDir, Incremental and Backfill aren't read from the
+// configuration file, but are filled from the crawler's command line arguments.
+// MaxBandwidth is expressed in kilobytes per second.
+// MaxKnownArticles is the number of consecutive links to known articles after
+// which the crawl of a website stops in incremental mode.
 type CrawlerConfig struct {
-	UserAgent  string        `yaml:"user_agent"`
-	RobotAgent string        `yaml:"robot_agent"`
-	CrawlDelay time.Duration `yaml:"crawl_delay"`
+	UserAgent             string            `yaml:"user_agent"`
+	RobotAgent            string            `yaml:"robot_agent"`
+	CrawlDelay            time.Duration     `yaml:"crawl_delay"`
+	MaxConcurrentWebsites int               `yaml:"max_concurrent_websites,omitempty"`
+	MaxFetchesPerHost     int               `yaml:"max_fetches_per_host,omitempty"`
+	MaxBandwidth          int64             `yaml:"max_bandwidth,omitempty"`
+	Retry                 *RetryConfig      `yaml:"retry,omitempty"`
+	CircuitBreaker        *BreakerConfig    `yaml:"circuit_breaker,omitempty"`
+	Transport             *TransportConfig  `yaml:"transport,omitempty"`
+	Renderer              *RendererConfig   `yaml:"renderer,omitempty"`
+	VisitedSet            *VisitedSetConfig `yaml:"visited_set,omitempty"`
+	MaxKnownArticles      int               `yaml:"max_known_articles,omitempty"`
+	RecordDir             string            `yaml:"-"`
+	ReplayDir             string            `yaml:"-"`
+	Incremental           bool              `yaml:"-"`
+	Backfill              *BackfillRange    `yaml:"-"`
+}
+
+// TransportConfig represents the configuration of the HTTP client used to send
+// requests to websites. It can be set for all websites in the crawler's
+// configuration, and overridden for a specific website, in which case the
+// website's settings take precedence over the global ones (see Merge).
+// The proxy is the URL of an HTTP, HTTPS or SOCKS5 proxy (e.g.
+// socks5://127.0.0.1:9050 for Tor). Timeouts are expressed in seconds.
+type TransportConfig struct {
+	Proxy              string        `yaml:"proxy,omitempty"`
+	CABundle           string        `yaml:"ca_bundle,omitempty"`
+	ClientCert         string        `yaml:"client_cert,omitempty"`
+	ClientKey          string        `yaml:"client_key,omitempty"`
+	Timeout            time.Duration `yaml:"timeout,omitempty"`
+	ConnectTimeout     time.Duration `yaml:"connect_timeout,omitempty"`
+	InsecureSkipVerify bool          `yaml:"insecure_skip_verify,omitempty"`
+}
+
+// Merge returns the configuration resulting from overriding the settings of a
+// transport configuration with the ones set in another one. Either of them
+// can be nil.
+func (t *TransportConfig) Merge(override *TransportConfig) *TransportConfig {
+	if t == nil {
+		return override
+	}
+	if override == nil {
+		return t
+	}
+
+	merged := *t
+	if len(override.Proxy) > 0 {
+		merged.Proxy = override.Proxy
+	}
+	if len(override.CABundle) > 0 {
+		merged.CABundle = override.CABundle
+	}
+	if len(override.ClientCert) > 0 {
+		merged.ClientCert = override.ClientCert
+		merged.ClientKey = override.ClientKey
+	}
+	if override.Timeout > 0 {
+		merged.Timeout = override.Timeout
+	}
+	if override.ConnectTimeout > 0 {
+		merged.ConnectTimeout = override.ConnectTimeout
+	}
+	merged.InsecureSkipVerify = merged.InsecureSkipVerify || override.InsecureSkipVerify
+
+	return &merged
+}
+
+// RetryConfig represents the configuration needed to retry requests that failed
+// because of a transient error, i.e. a network error, a 5xx status code or a 429
+// status code. Backoffs are expressed in seconds.
+type RetryConfig struct {
+	MaxRetries     int           `yaml:"max_retries"`
+	InitialBackoff time.Duration `yaml:"initial_backoff,omitempty"`
+	MaxBackoff     time.Duration `yaml:"max_backoff,omitempty"`
+}
+
+// BreakerConfig represents the configuration of the circuit breaker pausing the
+// requests to a host after a given number of consecutive failures, and aborting
+// the crawls of the websites on this host after it has been paused a given
+// number of times. The pause is expressed in seconds.
+type BreakerConfig struct {
+	Threshold int           `yaml:"threshold"`
+	Pause     time.Duration `yaml:"pause,omitempty"`
+	MaxTrips  int           `yaml:"max_trips,omitempty"`
+}
+
+// RendererConfig represents the configuration needed to render pages using a
+// headless browser controlled through the Chrome DevTools Protocol.
+type RendererConfig struct {
+	Endpoint       string        `yaml:"endpoint"`
+	Timeout        time.Duration `yaml:"timeout,omitempty"`
+	MaxConcurrency int           `yaml:"max_concurrency,omitempty"`
+}
+
+// VisitedSetConfig represents the configuration of the sets keeping track of
+// the articles already saved from each website, so their pages aren't visited
+// again. Type is either "memory", in which case the URLs of all saved articles
+// are loaded in memory, or "bloom", in which case they are stored in a Bloom
+// filter, saved in a file in Dir for each website, and URLs matching the
+// filter are checked against the database. FalsePositiveRate is the rate of
+// URLs Bloom filters match but the database doesn't contain.
+type VisitedSetConfig struct {
+	Type              string  `yaml:"type"`
+	Dir               string  `yaml:"dir,omitempty"`
+	FalsePositiveRate float64 `yaml:"false_positive_rate,omitempty"`
+}
+
+// BackfillRange represents the range of dates the archives of websites are
+// crawled for in backfill mode. Both dates are included.
+type BackfillRange struct {
+	Since time.Time
+	Until time.Time
+}
+
+// BackfillConfig represents the configuration needed to crawl the archives of a
+// website in backfill mode. ArchiveURLs are templates of the URLs of archive
+// pages, which can contain the same {PATTERN}s as date formats, and are filled
+// with each date of the backfill range. MaxVisits is the maximum number of
+// requests sent during a backfill, and MaxDepth the maximum number of links
+// followed from an archive page (defaults to 1, i.e. only the pages an archive
+// page links to are visited).
+type BackfillConfig struct {
+	ArchiveURLs stringList `yaml:"archive_url"`
+	MaxVisits   int        `yaml:"max_visits,omitempty"`
+	MaxDepth    int        `yaml:"max_depth,omitempty"`
 }
 
 // Website represents the configuration needed to describe a website a crawler
 // will explore.
+// RecheckInterval is the time, in seconds, during which pages that are neither
+// articles nor listing pages aren't visited again after being visited once.
+// DateFormats contains all of the website's date formats, including DateFormat,
+// once the configuration is checked. DateURLPattern is a regexp matching the
+// date in the URL of an article, with groups named "year", "month" and,
+// optionally, "day", which is compiled into DateURLRegexp once the
+// configuration is checked. Timezone is the IANA name of the time zone the
+// website's dates are written in when they don't specify one (defaults to UTC),
+// which is loaded into Location once the configuration is checked.
 type Website struct {
-	Identifier string        `yaml:"identifier"`
-	StartPoint string        `yaml:"start_point"`
-	Selectors  CSSSelectors  `yaml:"selectors"`
-	DateFormat string        `yaml:"date_format"`
-	MaxVisits  int           `yaml:"max_visits,omitempty"`
-	Query      *QueryConfig  `yaml:"ignore,omitempty"`
-	Filters    *CrawlFilters `yaml:"filters,omitempty"`
+	Identifier      string            `yaml:"identifier"`
+	StartPoint      string            `yaml:"start_point"`
+	Selectors       CSSSelectors      `yaml:"selectors"`
+	DateFormat      string            `yaml:"date_format,omitempty"`
+	DateFormats     stringList        `yaml:"date_formats,omitempty"`
+	DateURLPattern  string            `yaml:"date_url_pattern,omitempty"`
+	DateURLRegexp   *regexp.Regexp    `yaml:"-"`
+	Timezone        string            `yaml:"timezone,omitempty"`
+	Location        *time.Location    `yaml:"-"`
+	MaxVisits       int               `yaml:"max_visits,omitempty"`
+	MaxDepth        int               `yaml:"max_depth,omitempty"`
+	RecheckInterval time.Duration     `yaml:"recheck_interval,omitempty"`
+	Render          bool              `yaml:"render,omitempty"`
+	Transport       *TransportConfig  `yaml:"transport,omitempty"`
+	Headers         map[string]string `yaml:"headers,omitempty"`
+	Session         *SessionConfig    `yaml:"session,omitempty"`
+	WARC            *WARCConfig       `yaml:"warc,omitempty"`
+	Query           *QueryConfig      `yaml:"query,omitempty"`
+	Filters         *CrawlFilters     `yaml:"filters,omitempty"`
+	Backfill        *BackfillConfig   `yaml:"backfill,omitempty"`
+}
+
+// SessionConfig represents the configuration needed to keep cookies across the
+// requests sent to a website, and across runs of the crawler, which is required
+// for websites that serve a consent wall or require a subscriber to log in.
+// The values of cookies, along with the values of the login form's fields, can
+// reference environment variables (e.g. ${ACME_PASSWORD}), so credentials don't
+// need to be written in the configuration file.
+type SessionConfig struct {
+	CookieFile string            `yaml:"cookie_file,omitempty"`
+	Cookies    map[string]string `yaml:"cookies,omitempty"`
+	Login      *LoginConfig      `yaml:"login,omitempty"`
+}
+
+// LoginConfig represents the configuration needed to submit a login or consent
+// form before crawling a website. If a cookie name is provided, the form is
+// only submitted if the session doesn't already have this cookie, and the
+// submission fails if it doesn't set it.
+type LoginConfig struct {
+	URL    string            `yaml:"url"`
+	Method string            `yaml:"method,omitempty"`
+	Fields map[string]string `yaml:"fields,omitempty"`
+	Cookie string            `yaml:"cookie,omitempty"`
+}
+
+// WARCConfig represents the configuration needed to archive a website's HTTP
+// requests and responses in WARC files.
+type WARCConfig struct {
+	Directory string `yaml:"directory"`
+	MaxSize   int64  `yaml:"max_size,omitempty"`
 }
 
 // QueryConfig represents the configuration needed in the case a crawler needs
@@ -69,48 +245,119 @@ type QueryConfig struct {
 	Except    []string `yaml:"except,omitempty"`
 }
 
-// CrawlFilters represents the filters to apply when crawling a website.
+// CrawlFilters represents the filters to apply when crawling a website. The
+// restrict and exclude filters decide which URLs are visited. If article
+// patterns are provided, only the pages which URL matches one of them are
+// parsed looking for an article, and the pages which URL matches one of the
+// listing patterns never are. If either are provided, only the links matching
+// one of them are followed, in addition to the start point.
+// Errors encountered when parsing the filters' regexps are kept so they can be
+// reported when checking the configuration, along with all other problems.
 type CrawlFilters struct {
-	Restrict *regexp.Regexp
-	Exclude  *regexp.Regexp
+	Restrict Patterns
+	Exclude  Patterns
+	Article  Patterns
+	Listing  Patterns
+	errs     map[string]error
 }
 
 // UnmarshalYAML parses the regexps specified as filters and prepare them to be
-// used when filtering the crawlers queues.
-// Returns an error if there was an issue parsing the YAML source or parsing one
-// of the regexps.
+// used when filtering the crawlers queues. Each filter can be either a single
+// regexp or a list of regexps. If one of the regexps can't be parsed, the
+// error is kept to be reported when checking the configuration.
+// Returns an error if there was an issue parsing the YAML source.
 func (c *CrawlFilters) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var cfg struct {
-		Restrict string `yaml:"restrict,omitempty"`
-		Exclude  string `yaml:"exclude,omitempty"`
+		Restrict stringList `yaml:"restrict,omitempty"`
+		Exclude  stringList `yaml:"exclude,omitempty"`
+		Article  stringList `yaml:"article_pattern,omitempty"`
+		Listing  stringList `yaml:"listing_pattern,omitempty"`
 	}
 
 	if err := unmarshal(&cfg); err != nil {
 		return err
 	}
 
-	// All filters are optional, so we need to make sure one has been filled
-	// before trying to parse it.
-	if len(cfg.Restrict) > 0 {
-		restrict, err := regexp.Compile(cfg.Restrict)
-		if err != nil {
-			return err
+	c.errs = make(map[string]error)
+
+	// All filters are optional, so compilePatterns returns nil for the ones
+	// that haven't been filled.
+	var err error
+	if c.Restrict, err = compilePatterns(cfg.Restrict); err != nil {
+		c.errs["restrict"] = err
+	}
+	if c.Exclude, err = compilePatterns(cfg.Exclude); err != nil {
+		c.errs["exclude"] = err
+	}
+	if c.Article, err = compilePatterns(cfg.Article); err != nil {
+		c.errs["article_pattern"] = err
+	}
+	if c.Listing, err = compilePatterns(cfg.Listing); err != nil {
+		c.errs["listing_pattern"] = err
+	}
+
+	return nil
+}
+
+// Patterns is a list of regexps.
+type Patterns []*regexp.Regexp
+
+// MatchString checks whether a string matches at least one of the regexps.
+func (p Patterns) MatchString(s string) bool {
+	for _, re := range p {
+		if re.MatchString(s) {
+			return true
 		}
-		c.Restrict = restrict
 	}
-	if len(cfg.Exclude) > 0 {
-		exclude, err := regexp.Compile(cfg.Exclude)
-		if err != nil {
-			return err
+
+	return false
+}
+
+// compilePatterns parses a list of regexps, ignoring empty ones. Returns nil if
+// the list is empty.
+// Returns an error if one of the regexps couldn't be parsed.
+func compilePatterns(list []string) (patterns Patterns, err error) {
+	for _, expr := range list {
+		if len(expr) == 0 {
+			continue
+		}
+
+		var re *regexp.Regexp
+		if re, err = regexp.Compile(expr); err != nil {
+			return nil, err
 		}
-		c.Exclude = exclude
+		patterns = append(patterns, re)
+	}
+
+	return
+}
+
+// stringList is a list of strings which can be written in the configuration
+// file either as a list or as a single string.
+type stringList []string
+
+// UnmarshalYAML parses either a single string or a list of strings.
+// Returns an error if the YAML source is neither.
+func (l *stringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var single string
+	if err := unmarshal(&single); err == nil {
+		*l = stringList{single}
+		return nil
+	}
+
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return err
 	}
+	*l = list
 
 	return nil
 }
 
 // CSSSelectors represents the CSS selectors used to locate the different
-// elements of a news item in a page.
+// elements of a news item in a page. The date selector can be followed by the
+// name of an attribute to read the date from instead of the node's text, e.g.
+// "time@datetime" (see SplitSelectorAttribute).
 type CSSSelectors struct {
 	Title       string `yaml:"title"`
 	Description string `yaml:"description,omitempty"`
@@ -120,6 +367,17 @@ type CSSSelectors struct {
 	Thumbnail   string `yaml:"thumbnail,omitempty"`
 }
 
+// SplitSelectorAttribute splits a selector which can be followed by "@" and the
+// name of an attribute (e.g. "time@datetime") into the CSS selector and the
+// attribute's name, which is empty if the selector isn't followed by one.
+func SplitSelectorAttribute(selector string) (css string, attr string) {
+	if m := selectorAttributeRegexp.FindStringSubmatch(selector); m != nil {
+		return m[1], m[2]
+	}
+
+	return selector, ""
+}
+
 // DatabaseConfig represents the needed configuration to talk to the database.
 // There's two supported drivers: "postgres" and "sqlite3".
 type DatabaseConfig struct {
@@ -127,25 +385,63 @@ type DatabaseConfig struct {
 	ConnectionData string `yaml:"connection_data"`
 }
 
+// LogComponents contains the names of the components which logs can be given a
+// specific level in the logging configuration.
+var LogComponents = []string{"crawler", "extender", "generator"}
+
+// LoggingConfig represents the configuration of the logs, which is shared by the
+// crawler and the feed generator.
+// The format is either "text" (the default) or "json". Levels are the ones
+// supported by logrus (e.g. "info", the default, or "debug"), and can be set
+// for each of the components listed in LogComponents. If no file is provided,
+// logs are written to the standard error.
+type LoggingConfig struct {
+	Format     string            `yaml:"format,omitempty"`
+	Level      string            `yaml:"level,omitempty"`
+	Components map[string]string `yaml:"components,omitempty"`
+	File       *LogFileConfig    `yaml:"file,omitempty"`
+}
+
+// LogFileConfig represents the configuration of the file logs are written to.
+// The file is rotated once it reaches a given size, in megabytes, and a given
+// number of rotated files are kept.
+type LogFileConfig struct {
+	Path       string `yaml:"path"`
+	MaxSize    int64  `yaml:"max_size,omitempty"`
+	MaxBackups int    `yaml:"max_backups,omitempty"`
+}
+
 // FeedsConfig represents the configuration of the feeds exposed by the RSS
 // generator.
+// Aggregates maps the names of aggregate feeds to the identifiers of the
+// websites they gather the articles of. If CollapseDuplicates is true, only the
+// most recent article of a group of near-duplicates is included in aggregate
+// feeds, along with links to the other ones.
 type FeedsConfig struct {
-	Type      FeedType
-	NbItems   int
-	Interface string
-	Port      int
+	Type               FeedType
+	NbItems            int
+	Interface          string
+	Port               int
+	CacheTTL           time.Duration
+	Aggregates         map[string][]string
+	CollapseDuplicates bool
+	typeErr            error
 }
 
 // UnmarshalYAML detects the type of feed and sets the right values into the
-// FeedsConfig instance.
-// Returns an error if decoding the YAML configuration failed, or if the provided
-// type is invalid (ie neither "rss" nor "atom").
+// FeedsConfig instance. If the provided type is invalid (ie neither "rss" nor
+// "atom"), the error is kept to be reported when checking the configuration.
+// Returns an error if decoding the YAML configuration failed.
 func (fc *FeedsConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var cfg struct {
-		Type      string `yaml:"type"`
-		NbItems   int    `yaml:"nb_items"`
-		Interface string `yaml:"interface"`
-		Port      int    `yaml:"port"`
+		Type      string        `yaml:"type"`
+		NbItems   int           `yaml:"nb_items"`
+		Interface string        `yaml:"interface"`
+		Port      int           `yaml:"port"`
+		CacheTTL  time.Duration `yaml:"cache_ttl,omitempty"`
+
+		Aggregates         map[string][]string `yaml:"aggregates,omitempty"`
+		CollapseDuplicates bool                `yaml:"collapse_duplicates,omitempty"`
 	}
 
 	if err := unmarshal(&cfg); err != nil {
@@ -160,49 +456,32 @@ func (fc *FeedsConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		fc.Type = FeedTypeAtom
 		break
 	default:
-		return fmt.Errorf("Invalid feed type: %s", cfg.Type)
+		fc.typeErr = fmt.Errorf("Invalid feed type: %s", cfg.Type)
 	}
 
 	fc.NbItems = cfg.NbItems
 	fc.Interface = cfg.Interface
 	fc.Port = cfg.Port
+	fc.CacheTTL = cfg.CacheTTL
+	fc.Aggregates = cfg.Aggregates
+	fc.CollapseDuplicates = cfg.CollapseDuplicates
 
 	return nil
 }
 
 // Load reads the configuration file located at a given path and loads it into
 // a Config instance.
-// Returns an error if there was an issue reading the file, parsing it, or if
-// one of the website's URL is invalid.
+// Returns an error if there was an issue reading the file, or if checking the
+// configuration (see Check) found problems in it, in which case the error lists
+// all of them.
 func Load(filePath string) (cfg *Config, err error) {
-	// Reads the configuration file.
-	content, err := ioutil.ReadFile(filePath)
+	cfg, problems, err := Check(filePath)
 	if err != nil {
 		return
 	}
 
-	// Parse the configuration file into the Config instance.
-	cfg = new(Config)
-	if err = yaml.Unmarshal(content, cfg); err != nil {
-		return
-	}
-
-	// Check the URL provided for each website.
-	for _, w := range cfg.Websites {
-		if _, err = url.Parse(w.StartPoint); err != nil {
-			err = fmt.Errorf(
-				"Start point for %s isn't a valid URL: %s", w.Identifier, err.Error(),
-			)
-			return
-		}
-
-		replaceLayoutPatterns(&w.DateFormat)
-	}
-
-	// Check if the database driver is supported.
-	if cfg.Database.DriverName != "postgres" && cfg.Database.DriverName != "sqlite3" {
-		err = fmt.Errorf("Unsupported database driver %s", cfg.Database.DriverName)
-		return
+	if len(problems) > 0 {
+		err = problems
 	}
 
 	return
@@ -18,25 +18,28 @@ package config
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
 // patterns contains the list of known patterns used in date layouts. The name
 // of each pattern is written without the curly brackets, which are added in the
 // replaceLayoutPatterns function.
 var patterns = map[string]string{
-	"DAY_LONG":    "Monday",
-	"DAY_SHORT":   "Mon",
-	"DAY_NUM":     "2",
-	"MONTH_LONG":  "January",
-	"MONTH_SHORT": "Jan",
-	"MONTH_NUM":   "1",
-	"YEAR_LONG":   "2006",
-	"YEAR_SHORT":  "06",
-	"HOURS":       "15",
-	"MINUTES":     "04",
-	"SECONDS":     "05",
-	"ZONE_OFFSET": "-0700",
-	"ZONE_ABBREV": "MST",
+	"DAY_LONG":         "Monday",
+	"DAY_SHORT":        "Mon",
+	"DAY_NUM":          "2",
+	"DAY_NUM_PADDED":   "02",
+	"MONTH_LONG":       "January",
+	"MONTH_SHORT":      "Jan",
+	"MONTH_NUM":        "1",
+	"MONTH_NUM_PADDED": "01",
+	"YEAR_LONG":        "2006",
+	"YEAR_SHORT":       "06",
+	"HOURS":            "15",
+	"MINUTES":          "04",
+	"SECONDS":          "05",
+	"ZONE_OFFSET":      "-0700",
+	"ZONE_ABBREV":      "MST",
 }
 
 // replaceLayoutPatterns replaces all known {PATTERN}s in the date layout (aka
@@ -64,3 +67,16 @@ func replaceLayoutPatterns(layout *string) {
 		*layout = strings.Replace(*layout, fmt.Sprintf("{%s}", pattern), replacement, -1)
 	}
 }
+
+// FormatLayoutPatterns replaces all known {PATTERN}s in a template (e.g. the URL
+// of an archive page) with the matching part of the given date. Unlike the
+// layouts passed to time.Format(), the rest of the template is left untouched,
+// even if it contains numbers or words time.Format() would replace.
+func FormatLayoutPatterns(template string, date time.Time) string {
+	return layoutPatternRegexp.ReplaceAllStringFunc(template, func(m string) string {
+		if layout, known := patterns[m[1:len(m)-1]]; known {
+			return date.Format(layout)
+		}
+		return m
+	})
+}
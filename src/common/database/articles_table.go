@@ -17,12 +17,14 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 	"time"
 
 	"common"
 )
 
-// Schema of the articles table.
+// Schema of the articles table. The type of the columns storing timestamps
+// depends on the database driver (see timestampType).
 const articlesSchema = `
 -- Store articles found while crawling
 CREATE TABLE IF NOT EXISTS articles (
@@ -38,8 +40,19 @@ CREATE TABLE IF NOT EXISTS articles (
 	content TEXT NOT NULL,
 	-- Article's author. Can be NULL.
 	author TEXT,
-	-- Article's date
-	date DATE NOT NULL
+	-- Article's publication date and time
+	date %[1]s NOT NULL,
+	-- Article's language, as an ISO 639-1 code (e.g. "fr"). Can be NULL.
+	language TEXT,
+	-- ID of the WARC record containing the HTTP response the article was
+	-- extracted from. Can be NULL.
+	warc_record_id TEXT,
+	-- SimHash fingerprint of the article's text, used to detect near-duplicate
+	-- articles. Can be NULL.
+	fingerprint BIGINT,
+	-- Date and time at which the article was crawled. Can be NULL for the
+	-- articles crawled before it was recorded.
+	crawled_at %[1]s
 );
 `
 
@@ -48,81 +61,169 @@ const selectArticlesURLsForWebsiteSQL = `
 	SELECT url FROM articles WHERE website = $1
 `
 
+// Count the articles posted on a website.
+const countArticlesForWebsiteSQL = `
+	SELECT COUNT(*) FROM articles WHERE website = $1
+`
+
+// Check whether an article with a given URL exists.
+const selectArticleExistsSQL = `
+	SELECT COUNT(*) FROM articles WHERE url = $1
+`
+
 // Retrieve all articles filtered by the website they were posted on, ordered by
 // date (in counter-chronological order) and limited to a given number of rows.
 const selectArticlesByDateForWebsiteWithLimitSQL = `
-	SELECT url, title, description, content, author, date
+	SELECT url, title, description, content, author, date, language, crawled_at
 	FROM articles WHERE website = $1 ORDER BY date DESC LIMIT $2
 `
 
+// Retrieve all articles written in a given language, filtered by the website
+// they were posted on, ordered by date (in counter-chronological order) and
+// limited to a given number of rows.
+const selectArticlesByDateForWebsiteAndLanguageWithLimitSQL = `
+	SELECT url, title, description, content, author, date, language, crawled_at
+	FROM articles WHERE website = $1 AND language = $2 ORDER BY date DESC LIMIT $3
+`
+
+// Retrieve the fingerprints of the articles published since a given date, along
+// with the URL of the article each of them duplicates, if any.
+const selectArticlesFingerprintsSinceSQL = `
+	SELECT a.url, a.fingerprint, d.original
+	FROM articles a LEFT JOIN duplicates d ON d.url = a.url
+	WHERE a.fingerprint IS NOT NULL AND a.date >= $1
+`
+
 // Insert a new article in the database.
 const insertArticleSQL = `
-	INSERT INTO articles (website, url, title, description, content, author, date)
-	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	INSERT INTO articles (website, url, title, description, content, author, date, language, warc_record_id, fingerprint, crawled_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 `
 
 type articlesStatements struct {
-	selectArticlesURLsForWebsiteStmt            *sql.Stmt
-	selectArticlesByDateForWebsiteWithLimitStmt *sql.Stmt
-	insertArticleStmt                           *sql.Stmt
+	selectArticlesURLsForWebsiteStmt                       *sql.Stmt
+	countArticlesForWebsiteStmt                            *sql.Stmt
+	selectArticleExistsStmt                                *sql.Stmt
+	selectArticlesByDateForWebsiteWithLimitStmt            *sql.Stmt
+	selectArticlesByDateForWebsiteAndLanguageWithLimitStmt *sql.Stmt
+	selectArticlesFingerprintsSinceStmt                    *sql.Stmt
+	insertArticleStmt                                      *sql.Stmt
 }
 
-// Create the table if it doesn't exist and prepare the SQL statements.
-func (a *articlesStatements) prepare(db *sql.DB) (err error) {
-	_, err = db.Exec(articlesSchema)
+// Create the table if it doesn't exist, add the columns that were added to the
+// schema after the table's creation, migrate the date column, which used to only
+// store dates, to a timestamp, and prepare the SQL statements.
+func (a *articlesStatements) prepare(db *sql.DB, driverName string) (err error) {
+	_, err = db.Exec(fmt.Sprintf(articlesSchema, timestampType(driverName)))
 	if err != nil {
 		return
 	}
+	if err = migrateColumnToTimestamp(db, driverName, "articles", "date"); err != nil {
+		return
+	}
+	if err = addColumnIfNotExists(db, "articles", "language", "TEXT"); err != nil {
+		return
+	}
+	if err = addColumnIfNotExists(db, "articles", "warc_record_id", "TEXT"); err != nil {
+		return
+	}
+	if err = addColumnIfNotExists(db, "articles", "fingerprint", "BIGINT"); err != nil {
+		return
+	}
+	if err = addColumnIfNotExists(db, "articles", "crawled_at", timestampType(driverName)); err != nil {
+		return
+	}
 	if a.selectArticlesURLsForWebsiteStmt, err = db.Prepare(selectArticlesURLsForWebsiteSQL); err != nil {
 		return
 	}
+	if a.countArticlesForWebsiteStmt, err = db.Prepare(countArticlesForWebsiteSQL); err != nil {
+		return
+	}
+	if a.selectArticleExistsStmt, err = db.Prepare(selectArticleExistsSQL); err != nil {
+		return
+	}
 	if a.selectArticlesByDateForWebsiteWithLimitStmt, err = db.Prepare(selectArticlesByDateForWebsiteWithLimitSQL); err != nil {
 		return
 	}
+	if a.selectArticlesByDateForWebsiteAndLanguageWithLimitStmt, err = db.Prepare(selectArticlesByDateForWebsiteAndLanguageWithLimitSQL); err != nil {
+		return
+	}
+	if a.selectArticlesFingerprintsSinceStmt, err = db.Prepare(selectArticlesFingerprintsSinceSQL); err != nil {
+		return
+	}
 	if a.insertArticleStmt, err = db.Prepare(insertArticleSQL); err != nil {
 		return
 	}
 	return
 }
 
-// insertArticle inserts an article into the database. description and author
-// are optional, so pass them as a pointer that can be set to nil if the row field
-// should be NULL.
+// insertArticle inserts an article into the database. The article's description,
+// author, language, WARC record ID and fingerprint are optional, so their row
+// fields will be NULL if they're set to nil in the article, and so is its crawl
+// time, which will be NULL if it's the zero time. Times are stored in UTC (see
+// timestampType).
 // Returns an error if there was an issue inserting the article.
-func (a *articlesStatements) insertArticle(
-	website string, url string, title string, description *string,
-	content string, author *string, date time.Time,
-) (err error) {
-	var descriptionNullable, authorNullable sql.NullString
-
-	// Optional fields.
-	descriptionNullable.Valid = description != nil
-	if descriptionNullable.Valid {
-		descriptionNullable.String = *description
+func (a *articlesStatements) insertArticle(website string, article *common.Article) (err error) {
+	// The fingerprint is stored as a signed integer, since that's the only
+	// 64-bit integer type both SQLite and PostgreSQL support.
+	var fingerprint sql.NullInt64
+	if article.Fingerprint != nil {
+		fingerprint.Valid = true
+		fingerprint.Int64 = int64(*article.Fingerprint)
 	}
-	authorNullable.Valid = author != nil
-	if authorNullable.Valid {
-		authorNullable.String = *author
+
+	var crawledAt interface{}
+	if !article.CrawledAt.IsZero() {
+		crawledAt = article.CrawledAt.UTC()
 	}
 
 	// Run the insertion.
 	_, err = a.insertArticleStmt.Exec(
-		website, url, title, descriptionNullable, content, authorNullable, date,
+		website, article.URL, article.Title, nullableString(article.Description),
+		article.Content, nullableString(article.Author), article.Date.UTC(),
+		nullableString(article.Language), nullableString(article.WARCRecordID),
+		fingerprint, crawledAt,
 	)
 
 	return
 }
 
+// selectArticlesFingerprintsSince returns the fingerprints of the articles
+// published since a given date.
+// Returns an error if there was an issue performing the query or reading the
+// rows it returned.
+func (a *articlesStatements) selectArticlesFingerprintsSince(
+	since time.Time,
+) (fingerprints []common.ArticleFingerprint, err error) {
+	rows, err := a.selectArticlesFingerprintsSinceStmt.Query(since.UTC())
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	var original sql.NullString
+	var fingerprint int64
+	for rows.Next() {
+		var f common.ArticleFingerprint
+		if err = rows.Scan(&f.URL, &fingerprint, &original); err != nil {
+			return
+		}
+
+		f.Fingerprint = uint64(fingerprint)
+		if original.Valid {
+			f.Original = original.String
+		}
+		fingerprints = append(fingerprints, f)
+	}
+
+	return
+}
+
 // selectArticlesURLsForWebsite returns the URLs of all articles published on
 // a given website.
 // Returns an error if there was an issue requesting the URLs from the database,
 // or extracting the data from the rows.
 func (a *articlesStatements) selectArticlesURLsForWebsite(website string) (urls map[string]bool, err error) {
-	rows, err := a.selectArticlesURLsForWebsiteStmt.Query(website)
-	if err != nil {
-		return
-	}
-
 	// Using a map instead of an array here because we're going to store a lot
 	// of URLs (hundreds, thousands, or even more) that we'll need to access
 	// very frequently, and benchmarks show that retrieval is faster on maps
@@ -130,18 +231,55 @@ func (a *articlesStatements) selectArticlesURLsForWebsite(website string) (urls
 	urls = make(map[string]bool)
 
 	// Retrieve the URLs and save them in the map.
+	err = a.forEachArticleURLForWebsite(website, func(url string) {
+		urls[url] = true
+	})
+
+	return
+}
+
+// forEachArticleURLForWebsite calls a given function with the URL of each
+// article published on a given website, without keeping all of them in memory.
+// Returns an error if there was an issue requesting the URLs from the database,
+// or extracting the data from the rows.
+func (a *articlesStatements) forEachArticleURLForWebsite(website string, fn func(url string)) (err error) {
+	rows, err := a.selectArticlesURLsForWebsiteStmt.Query(website)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
 	var url string
 	for rows.Next() {
 		if err = rows.Scan(&url); err != nil {
 			return
 		}
 
-		urls[url] = true
+		fn(url)
 	}
 
+	return rows.Err()
+}
+
+// countArticlesForWebsite returns the number of articles published on a given
+// website.
+// Returns an error if there was an issue performing the query.
+func (a *articlesStatements) countArticlesForWebsite(website string) (count int, err error) {
+	err = a.countArticlesForWebsiteStmt.QueryRow(website).Scan(&count)
 	return
 }
 
+// selectArticleExists checks whether an article with a given URL exists.
+// Returns an error if there was an issue performing the query.
+func (a *articlesStatements) selectArticleExists(url string) (exists bool, err error) {
+	var count int
+	if err = a.selectArticleExistsStmt.QueryRow(url).Scan(&count); err != nil {
+		return
+	}
+
+	return count > 0, nil
+}
+
 // selectArticlesByDateForWebsiteWithLimit returns a representation of the latest
 // n articles, ordered by date, for a given website, n being a given limit to the
 // set.
@@ -154,18 +292,49 @@ func (a *articlesStatements) selectArticlesByDateForWebsiteWithLimit(website str
 		return
 	}
 
+	return scanArticles(rows)
+}
+
+// selectArticlesByDateForWebsiteAndLanguageWithLimit returns a representation of
+// the latest n articles written in a given language, ordered by date, for a given
+// website, n being a given limit to the set.
+// Returns an error if there was an issue performing the query or reading the rows
+// it returned.
+func (a *articlesStatements) selectArticlesByDateForWebsiteAndLanguageWithLimit(
+	website string, language string, limit int,
+) (articles []common.Article, err error) {
+	// Perform the query.
+	rows, err := a.selectArticlesByDateForWebsiteAndLanguageWithLimitStmt.Query(
+		website, language, limit,
+	)
+	if err != nil {
+		return
+	}
+
+	return scanArticles(rows)
+}
+
+// scanArticles reads articles from rows returned by a query selecting the url,
+// title, description, content, author, date, language and crawled_at columns,
+// in this order. Times are returned in UTC, whatever the database driver.
+// Returns an error if there was an issue reading the rows.
+func scanArticles(rows *sql.Rows) (articles []common.Article, err error) {
+	defer rows.Close()
+
 	// Initialise the slice.
 	articles = []common.Article{}
 
 	// Declare variables to avoid unnecessary allocations.
 	var article common.Article
 	var url, title, content string
-	var description, author sql.NullString
+	var description, author, language sql.NullString
 	var date time.Time
+	// The crawl time is NULL for the articles crawled before it was recorded.
+	var crawledAt *time.Time
 	// Iterate over the rows.
 	for rows.Next() {
 		// "Load" content into the variables.
-		if err = rows.Scan(&url, &title, &description, &content, &author, &date); err != nil {
+		if err = rows.Scan(&url, &title, &description, &content, &author, &date, &language, &crawledAt); err != nil {
 			return
 		}
 
@@ -174,7 +343,12 @@ func (a *articlesStatements) selectArticlesByDateForWebsiteWithLimit(website str
 			URL:     url,
 			Title:   title,
 			Content: content,
-			Date:    date,
+			Date:    date.UTC(),
+		}
+
+		// Fill the crawl time if it's not NULL.
+		if crawledAt != nil {
+			article.CrawledAt = crawledAt.UTC()
 		}
 
 		// Fill the description if it's not NULL.
@@ -195,6 +369,15 @@ func (a *articlesStatements) selectArticlesByDateForWebsiteWithLimit(website str
 			article.Author = nil
 		}
 
+		// Fill the language if it's not NULL.
+		if language.Valid {
+			// Re-allocating to be sure the referenced value won't change.
+			langStr := language.String
+			article.Language = &langStr
+		} else {
+			article.Language = nil
+		}
+
 		// Append the article to the slice.
 		articles = append(articles, article)
 	}
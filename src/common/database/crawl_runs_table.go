@@ -0,0 +1,181 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"common"
+)
+
+// Schema of the crawl_runs table.
+const crawlRunsSchema = `
+-- Store the extraction statistics of each run of the crawler
+CREATE TABLE IF NOT EXISTS crawl_runs (
+	-- Website the crawler ran on
+	website TEXT NOT NULL,
+	-- Time the run started at
+	started_at TIMESTAMP NOT NULL,
+	-- Time the run ended at
+	ended_at TIMESTAMP NOT NULL,
+	-- Number of pages visited during the run
+	pages_visited INTEGER NOT NULL,
+	-- Number of visited pages expected to be articles
+	article_pages_visited INTEGER NOT NULL DEFAULT 0,
+	-- Number of articles saved during the run
+	articles_saved INTEGER NOT NULL,
+	-- Number of articles which date couldn't be parsed
+	date_parse_failures INTEGER NOT NULL,
+	-- Number of visited pages expected to be articles each selector matched
+	-- in, as a JSON object which keys are the selectors' names
+	selector_matches TEXT NOT NULL,
+	-- Whether the website was flagged as degraded at the end of the run
+	degraded BOOLEAN NOT NULL
+);
+`
+
+// Retrieve the runs on a website, ordered by start time (in counter-chronological
+// order) and limited to a given number of rows.
+const selectCrawlRunsForWebsiteWithLimitSQL = `
+	SELECT website, started_at, ended_at, pages_visited, article_pages_visited, articles_saved, date_parse_failures, selector_matches, degraded
+	FROM crawl_runs WHERE website = $1 ORDER BY started_at DESC LIMIT $2
+`
+
+// Retrieve the latest run on each website, ordered by website.
+const selectLatestCrawlRunsSQL = `
+	SELECT website, started_at, ended_at, pages_visited, article_pages_visited, articles_saved, date_parse_failures, selector_matches, degraded
+	FROM crawl_runs r WHERE started_at = (
+		SELECT MAX(started_at) FROM crawl_runs WHERE website = r.website
+	) ORDER BY website
+`
+
+// Insert a new run in the database.
+const insertCrawlRunSQL = `
+	INSERT INTO crawl_runs (website, started_at, ended_at, pages_visited, article_pages_visited, articles_saved, date_parse_failures, selector_matches, degraded)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+`
+
+type crawlRunsStatements struct {
+	selectCrawlRunsForWebsiteWithLimitStmt *sql.Stmt
+	selectLatestCrawlRunsStmt              *sql.Stmt
+	insertCrawlRunStmt                     *sql.Stmt
+}
+
+// Create the table if it doesn't exist, add the columns that were added to the
+// schema after the table's creation, and prepare the SQL statements.
+func (c *crawlRunsStatements) prepare(db *sql.DB) (err error) {
+	_, err = db.Exec(crawlRunsSchema)
+	if err != nil {
+		return
+	}
+	if err = addColumnIfNotExists(db, "crawl_runs", "article_pages_visited", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return
+	}
+	if c.selectCrawlRunsForWebsiteWithLimitStmt, err = db.Prepare(selectCrawlRunsForWebsiteWithLimitSQL); err != nil {
+		return
+	}
+	if c.selectLatestCrawlRunsStmt, err = db.Prepare(selectLatestCrawlRunsSQL); err != nil {
+		return
+	}
+	if c.insertCrawlRunStmt, err = db.Prepare(insertCrawlRunSQL); err != nil {
+		return
+	}
+	return
+}
+
+// insertCrawlRun inserts a run into the database.
+// Returns an error if there was an issue serialising the selectors' matches or
+// inserting the run.
+func (c *crawlRunsStatements) insertCrawlRun(run *common.CrawlRun) (err error) {
+	matches, err := json.Marshal(run.SelectorMatches)
+	if err != nil {
+		return
+	}
+
+	// Run the insertion.
+	_, err = c.insertCrawlRunStmt.Exec(
+		run.Website, run.StartedAt, run.EndedAt, run.PagesVisited, run.ArticlePagesVisited,
+		run.ArticlesSaved, run.DateParseFailures, string(matches), run.Degraded,
+	)
+
+	return
+}
+
+// selectCrawlRunsForWebsiteWithLimit returns the latest n runs on a given
+// website, ordered by start time, n being a given limit to the set.
+// Returns an error if there was an issue performing the query or reading the rows
+// it returned.
+func (c *crawlRunsStatements) selectCrawlRunsForWebsiteWithLimit(website string, limit int) ([]common.CrawlRun, error) {
+	// Perform the query.
+	rows, err := c.selectCrawlRunsForWebsiteWithLimitStmt.Query(website, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanCrawlRuns(rows)
+}
+
+// selectLatestCrawlRuns returns the latest run on each website.
+// Returns an error if there was an issue performing the query or reading the rows
+// it returned.
+func (c *crawlRunsStatements) selectLatestCrawlRuns() ([]common.CrawlRun, error) {
+	// Perform the query.
+	rows, err := c.selectLatestCrawlRunsStmt.Query()
+	if err != nil {
+		return nil, err
+	}
+
+	return scanCrawlRuns(rows)
+}
+
+// scanCrawlRuns reads runs from rows returned by a query selecting the website,
+// started_at, ended_at, pages_visited, article_pages_visited, articles_saved,
+// date_parse_failures, selector_matches and degraded columns, in this order.
+// Returns an error if there was an issue reading the rows or decoding the
+// selectors' matches.
+func scanCrawlRuns(rows *sql.Rows) (runs []common.CrawlRun, err error) {
+	defer rows.Close()
+
+	// Initialise the slice.
+	runs = []common.CrawlRun{}
+
+	var run common.CrawlRun
+	var matches string
+	var startedAt, endedAt time.Time
+	// Iterate over the rows.
+	for rows.Next() {
+		run = common.CrawlRun{}
+		if err = rows.Scan(
+			&run.Website, &startedAt, &endedAt, &run.PagesVisited, &run.ArticlePagesVisited,
+			&run.ArticlesSaved, &run.DateParseFailures, &matches, &run.Degraded,
+		); err != nil {
+			return
+		}
+
+		run.StartedAt = startedAt.UTC()
+		run.EndedAt = endedAt.UTC()
+
+		if err = json.Unmarshal([]byte(matches), &run.SelectorMatches); err != nil {
+			return
+		}
+
+		runs = append(runs, run)
+	}
+
+	return
+}
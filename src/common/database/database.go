@@ -31,8 +31,12 @@ import (
 
 // Database represents the crawler's database.
 type Database struct {
-	db       *sql.DB
-	articles articlesStatements
+	db         *sql.DB
+	articles   articlesStatements
+	crawlRuns  crawlRunsStatements
+	pages      pageValidatorsStatements
+	duplicates duplicatesStatements
+	seenURLs   seenURLsStatements
 }
 
 // NewDatabase creates a new instance of the Database structure by opening a
@@ -46,30 +50,43 @@ func NewDatabase(cfg config.DatabaseConfig) (database *Database, err error) {
 	if database.db, err = sql.Open(cfg.DriverName, cfg.ConnectionData); err != nil {
 		return
 	}
-	if err = database.articles.prepare(database.db); err != nil {
+	// The duplicates table needs to exist before preparing the statements of
+	// the articles table, since some of them use it.
+	if err = database.duplicates.prepare(database.db); err != nil {
+		return
+	}
+	if err = database.articles.prepare(database.db, cfg.DriverName); err != nil {
+		return
+	}
+	if err = database.crawlRuns.prepare(database.db); err != nil {
+		return
+	}
+	if err = database.pages.prepare(database.db); err != nil {
+		return
+	}
+	if err = database.seenURLs.prepare(database.db); err != nil {
 		return
 	}
 
 	return
 }
 
-// SaveArticle saves an article into the database. description and author are
-// optional, so pass them as a pointer that can be set to nil if the row field
-// should be NULL.
+// SaveArticle saves an article into the database. The article's description,
+// author, language and WARC record ID are optional, so they can be set to nil if
+// the row field should be NULL.
 // Returns an error if the insertion failed, or if the article's URL is invalid.
-func (d *Database) SaveArticle(
-	website string, articleURL *url.URL, title string,
-	description *string, content string, author *string, date time.Time,
-) error {
+func (d *Database) SaveArticle(website string, article *common.Article) error {
 	// Check the article's URL.
+	articleURL, err := url.Parse(article.URL)
+	if err != nil {
+		return err
+	}
 	if articleURL.Scheme != "http" && articleURL.Scheme != "https" {
 		return fmt.Errorf("Unsupported protocol scheme for provided URL: %s", articleURL.Scheme)
 	}
 
 	// Perform the insertion.
-	return d.articles.insertArticle(
-		website, articleURL.String(), title, description, content, author, date,
-	)
+	return d.articles.insertArticle(website, article)
 }
 
 // RetrieveArticleURLsForWebsite retrieves from the database all articles that
@@ -79,6 +96,27 @@ func (d *Database) RetrieveArticleURLsForWebsite(website string) (map[string]boo
 	return d.articles.selectArticlesURLsForWebsite(website)
 }
 
+// ForEachArticleURLForWebsite calls a given function with the URL of each
+// article that was published on a given website, without retrieving all of
+// them at once.
+// Returns an error if the retrieval failed.
+func (d *Database) ForEachArticleURLForWebsite(website string, fn func(url string)) error {
+	return d.articles.forEachArticleURLForWebsite(website, fn)
+}
+
+// CountArticlesForWebsite returns the number of articles that were published on
+// a given website.
+// Returns an error if the retrieval failed.
+func (d *Database) CountArticlesForWebsite(website string) (int, error) {
+	return d.articles.countArticlesForWebsite(website)
+}
+
+// HasArticle checks whether an article was saved under a given URL.
+// Returns an error if the retrieval failed.
+func (d *Database) HasArticle(url string) (bool, error) {
+	return d.articles.selectArticleExists(url)
+}
+
 // RetrieveNLatestArticlesForWebsite returns a representation of the latest
 // n articles, ordered by date, for a given website, n being a given limit to
 // the set.
@@ -86,3 +124,162 @@ func (d *Database) RetrieveArticleURLsForWebsite(website string) (map[string]boo
 func (d *Database) RetrieveNLatestArticlesForWebsite(website string, n int) ([]common.Article, error) {
 	return d.articles.selectArticlesByDateForWebsiteWithLimit(website, n)
 }
+
+// RetrieveNLatestArticlesForWebsiteInLanguage returns a representation of the
+// latest n articles written in a given language, ordered by date, for a given
+// website, n being a given limit to the set.
+// Returns an error if the retrieval failed.
+func (d *Database) RetrieveNLatestArticlesForWebsiteInLanguage(
+	website string, language string, n int,
+) ([]common.Article, error) {
+	return d.articles.selectArticlesByDateForWebsiteAndLanguageWithLimit(website, language, n)
+}
+
+// RetrieveArticlesFingerprintsSince retrieves the fingerprints of the articles
+// published since a given date, from all websites.
+// Returns an error if the retrieval failed.
+func (d *Database) RetrieveArticlesFingerprintsSince(since time.Time) ([]common.ArticleFingerprint, error) {
+	return d.articles.selectArticlesFingerprintsSince(since)
+}
+
+// SaveDuplicate saves into the database that the article at a given URL is a
+// near-duplicate of another article, the given distance being the number of
+// bits that differ between their fingerprints.
+// Returns an error if the insertion failed.
+func (d *Database) SaveDuplicate(url string, original string, distance int) error {
+	return d.duplicates.insertDuplicate(url, original, distance)
+}
+
+// RetrieveDuplicates retrieves the URLs of the other articles of the group of
+// near-duplicates the article at a given URL belongs to. Returns an empty slice
+// if the article doesn't have any near-duplicate.
+// Returns an error if the retrieval failed.
+func (d *Database) RetrieveDuplicates(url string) ([]string, error) {
+	return d.duplicates.selectDuplicates(url)
+}
+
+// SaveCrawlRun saves the statistics of a run of the crawler on a website into
+// the database.
+// Returns an error if the insertion failed.
+func (d *Database) SaveCrawlRun(run *common.CrawlRun) error {
+	return d.crawlRuns.insertCrawlRun(run)
+}
+
+// RetrieveNLatestCrawlRunsForWebsite returns the statistics of the latest n runs
+// of the crawler on a given website, ordered by start time, n being a given limit
+// to the set.
+// Returns an error if the retrieval failed.
+func (d *Database) RetrieveNLatestCrawlRunsForWebsite(website string, n int) ([]common.CrawlRun, error) {
+	return d.crawlRuns.selectCrawlRunsForWebsiteWithLimit(website, n)
+}
+
+// RetrieveLatestCrawlRuns returns the statistics of the latest run of the
+// crawler on each website, ordered by website.
+// Returns an error if the retrieval failed.
+func (d *Database) RetrieveLatestCrawlRuns() ([]common.CrawlRun, error) {
+	return d.crawlRuns.selectLatestCrawlRuns()
+}
+
+// SavePageValidators saves the validators a website sent along with a page, and
+// the links found in the page, into the database, replacing the ones previously
+// saved for the same page.
+// Returns an error if the insertion failed.
+func (d *Database) SavePageValidators(website string, validators *common.PageValidators) error {
+	return d.pages.upsertPageValidators(website, validators)
+}
+
+// RetrievePageValidators returns the validators saved for the page at a given
+// URL, or nil if none were saved.
+// Returns an error if the retrieval failed.
+func (d *Database) RetrievePageValidators(u string) (*common.PageValidators, error) {
+	return d.pages.selectPageValidators(u)
+}
+
+// SaveSeenURL saves the last fetch of a page that isn't an article into the
+// database, replacing the one previously saved for the same page.
+// Returns an error if the insertion failed.
+func (d *Database) SaveSeenURL(website string, seen *common.SeenURL) error {
+	return d.seenURLs.upsertSeenURL(website, seen)
+}
+
+// RetrieveSeenURLsForWebsiteSince returns the URLs of the pages of a given
+// website that aren't articles nor listing pages, and were fetched since a
+// given date.
+// Returns an error if the retrieval failed.
+func (d *Database) RetrieveSeenURLsForWebsiteSince(website string, since time.Time) (map[string]bool, error) {
+	return d.seenURLs.selectSeenURLsForWebsiteSince(website, since)
+}
+
+// addColumnIfNotExists adds a column to an existing table if the table doesn't
+// already have it. This is used to update the tables created with an older
+// version of the schema, since "CREATE TABLE IF NOT EXISTS" won't do it.
+// Returns an error if the column couldn't be added.
+func addColumnIfNotExists(db *sql.DB, table string, column string, definition string) error {
+	// If selecting the column works, it means it already exists. We're not
+	// using the information schema here because SQLite doesn't implement it.
+	if _, err := db.Exec(fmt.Sprintf("SELECT %s FROM %s LIMIT 0", column, table)); err == nil {
+		return nil
+	}
+
+	_, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// timestampType returns the type of the columns storing timezone-aware
+// timestamps with a given database driver. SQLite doesn't have such a type, and
+// stores times as text in TIMESTAMP columns, which are compared as strings, so
+// times must always be converted to UTC before being stored for them to be
+// ordered and compared correctly, whatever the driver.
+func timestampType(driverName string) string {
+	if driverName == "postgres" {
+		return "TIMESTAMP WITH TIME ZONE"
+	}
+
+	return "TIMESTAMP"
+}
+
+// migrateColumnToTimestamp changes the type of a column of an existing table to
+// a timezone-aware timestamp if it isn't one already, considering the dates and
+// times it contains are in UTC. This is only needed with PostgreSQL, since
+// SQLite stores times as text whatever the column's type (see timestampType).
+// Returns an error if the column's type couldn't be retrieved or changed.
+func migrateColumnToTimestamp(db *sql.DB, driverName string, table string, column string) error {
+	if driverName != "postgres" {
+		return nil
+	}
+
+	var dataType string
+	err := db.QueryRow(`
+		SELECT data_type FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2
+	`, table, column).Scan(&dataType)
+	if err != nil || dataType == "timestamp with time zone" {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf(
+		`ALTER TABLE %[1]s ALTER COLUMN "%[2]s" TYPE TIMESTAMP WITH TIME ZONE USING "%[2]s"::TIMESTAMP AT TIME ZONE 'UTC'`,
+		table, column,
+	))
+	return err
+}
+
+// nullableString converts a pointer to a string, which can be nil, into a
+// sql.NullString, which value will be NULL if the pointer is nil.
+func nullableString(str *string) (nullable sql.NullString) {
+	nullable.Valid = str != nil
+	if nullable.Valid {
+		nullable.String = *str
+	}
+
+	return
+}
+
+// nonEmpty returns a pointer to a given string, or nil if the string is empty.
+func nonEmpty(str string) *string {
+	if len(str) == 0 {
+		return nil
+	}
+
+	return &str
+}
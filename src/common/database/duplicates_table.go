@@ -0,0 +1,101 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Schema of the duplicates table.
+const duplicatesSchema = `
+-- Store the articles which text is nearly identical to the one of an article
+-- saved before them (e.g. a wire story published by several websites)
+CREATE TABLE IF NOT EXISTS duplicates (
+	-- URL of the article
+	url TEXT NOT NULL PRIMARY KEY,
+	-- URL of the first saved article of the group of near-duplicates the
+	-- article belongs to
+	original TEXT NOT NULL,
+	-- Number of bits that differ between the articles' fingerprints
+	distance INTEGER NOT NULL,
+	-- Time the duplicate was detected at
+	detected_at TIMESTAMP NOT NULL
+);
+`
+
+// Retrieve the URLs of the other articles of the group of near-duplicates an
+// article belongs to, including the group's original article.
+const selectDuplicatesSQL = `
+	SELECT url FROM duplicates
+	WHERE original = COALESCE((SELECT original FROM duplicates WHERE url = $1), $1) AND url <> $1
+	UNION
+	SELECT original FROM duplicates WHERE url = $1
+`
+
+// Insert a new duplicate in the database.
+const insertDuplicateSQL = `
+	INSERT INTO duplicates (url, original, distance, detected_at) VALUES ($1, $2, $3, $4)
+`
+
+type duplicatesStatements struct {
+	selectDuplicatesStmt *sql.Stmt
+	insertDuplicateStmt  *sql.Stmt
+}
+
+// Create the table if it doesn't exist and prepare the SQL statements.
+func (d *duplicatesStatements) prepare(db *sql.DB) (err error) {
+	_, err = db.Exec(duplicatesSchema)
+	if err != nil {
+		return
+	}
+	if d.selectDuplicatesStmt, err = db.Prepare(selectDuplicatesSQL); err != nil {
+		return
+	}
+	if d.insertDuplicateStmt, err = db.Prepare(insertDuplicateSQL); err != nil {
+		return
+	}
+	return
+}
+
+// insertDuplicate inserts a duplicate into the database.
+// Returns an error if there was an issue inserting the duplicate.
+func (d *duplicatesStatements) insertDuplicate(url string, original string, distance int) (err error) {
+	_, err = d.insertDuplicateStmt.Exec(url, original, distance, time.Now().UTC())
+	return
+}
+
+// selectDuplicates returns the URLs of the other articles of the group of
+// near-duplicates the article at a given URL belongs to.
+// Returns an error if there was an issue performing the query or reading the
+// rows it returned.
+func (d *duplicatesStatements) selectDuplicates(url string) (urls []string, err error) {
+	rows, err := d.selectDuplicatesStmt.Query(url)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	var u string
+	for rows.Next() {
+		if err = rows.Scan(&u); err != nil {
+			return
+		}
+		urls = append(urls, u)
+	}
+
+	return
+}
@@ -0,0 +1,141 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"common"
+)
+
+// Schema of the page_validators table.
+const pageValidatorsSchema = `
+-- Store the validators websites sent along with the pages that aren't articles,
+-- so these pages can be requested conditionally, and the links found in them
+CREATE TABLE IF NOT EXISTS page_validators (
+	-- URL of the page
+	url TEXT NOT NULL PRIMARY KEY,
+	-- Website the page belongs to
+	website TEXT NOT NULL,
+	-- Value of the page's ETag header
+	etag TEXT,
+	-- Value of the page's Last-Modified header
+	last_modified TEXT,
+	-- Links found in the page, as a JSON array
+	links TEXT NOT NULL,
+	-- Time the validators were last updated at
+	updated_at TIMESTAMP NOT NULL
+);
+`
+
+// Retrieve the validators of a page.
+const selectPageValidatorsSQL = `
+	SELECT url, etag, last_modified, links FROM page_validators WHERE url = $1
+`
+
+// Update the validators of a page.
+const updatePageValidatorsSQL = `
+	UPDATE page_validators SET website = $2, etag = $3, last_modified = $4, links = $5, updated_at = $6
+	WHERE url = $1
+`
+
+// Insert the validators of a page which validators aren't already known.
+const insertPageValidatorsSQL = `
+	INSERT INTO page_validators (url, website, etag, last_modified, links, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+`
+
+type pageValidatorsStatements struct {
+	selectPageValidatorsStmt *sql.Stmt
+	updatePageValidatorsStmt *sql.Stmt
+	insertPageValidatorsStmt *sql.Stmt
+}
+
+// Create the table if it doesn't exist and prepare the SQL statements.
+func (p *pageValidatorsStatements) prepare(db *sql.DB) (err error) {
+	_, err = db.Exec(pageValidatorsSchema)
+	if err != nil {
+		return
+	}
+	if p.selectPageValidatorsStmt, err = db.Prepare(selectPageValidatorsSQL); err != nil {
+		return
+	}
+	if p.updatePageValidatorsStmt, err = db.Prepare(updatePageValidatorsSQL); err != nil {
+		return
+	}
+	if p.insertPageValidatorsStmt, err = db.Prepare(insertPageValidatorsSQL); err != nil {
+		return
+	}
+	return
+}
+
+// upsertPageValidators inserts the validators of a page into the database, or
+// updates them if the page's validators are already known. An update followed
+// by an insertion is used instead of an upsert statement, since not all
+// supported versions of SQLite implement it.
+// Returns an error if there was an issue serialising the page's links, or
+// updating or inserting the validators.
+func (p *pageValidatorsStatements) upsertPageValidators(
+	website string, validators *common.PageValidators,
+) (err error) {
+	links, err := json.Marshal(validators.Links)
+	if err != nil {
+		return
+	}
+
+	args := []interface{}{
+		validators.URL, website, nullableString(nonEmpty(validators.ETag)),
+		nullableString(nonEmpty(validators.LastModified)), string(links), time.Now().UTC(),
+	}
+
+	res, err := p.updatePageValidatorsStmt.Exec(args...)
+	if err != nil {
+		return
+	}
+	if updated, err := res.RowsAffected(); err != nil || updated > 0 {
+		return err
+	}
+
+	_, err = p.insertPageValidatorsStmt.Exec(args...)
+	return
+}
+
+// selectPageValidators returns the validators of the page at a given URL, or
+// nil if they aren't known.
+// Returns an error if there was an issue performing the query or reading the
+// row it returned.
+func (p *pageValidatorsStatements) selectPageValidators(u string) (*common.PageValidators, error) {
+	var validators common.PageValidators
+	var etag, lastModified sql.NullString
+	var links string
+
+	err := p.selectPageValidatorsStmt.QueryRow(u).Scan(&validators.URL, &etag, &lastModified, &links)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	validators.ETag = etag.String
+	validators.LastModified = lastModified.String
+	if err = json.Unmarshal([]byte(links), &validators.Links); err != nil {
+		return nil, err
+	}
+
+	return &validators, nil
+}
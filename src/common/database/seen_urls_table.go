@@ -0,0 +1,130 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"common"
+)
+
+// Schema of the seen_urls table.
+const seenURLsSchema = `
+-- Store the last time each page that isn't an article was fetched, so the ones
+-- that don't need to be visited during each run can be skipped
+CREATE TABLE IF NOT EXISTS seen_urls (
+	-- URL of the page
+	url TEXT NOT NULL PRIMARY KEY,
+	-- Website the page belongs to
+	website TEXT NOT NULL,
+	-- Classification of the page ("listing", "other" or "error")
+	classification TEXT NOT NULL,
+	-- Status code of the response to the last request for the page
+	status INTEGER NOT NULL,
+	-- Time the page was last fetched at
+	fetched_at TIMESTAMP NOT NULL
+);
+`
+
+// Retrieve the URLs of the pages of a website that were fetched since a given
+// date, except the ones with a given classification.
+const selectSeenURLsForWebsiteSinceSQL = `
+	SELECT url FROM seen_urls WHERE website = $1 AND classification <> $2 AND fetched_at >= $3
+`
+
+// Update the last fetch of a page.
+const updateSeenURLSQL = `
+	UPDATE seen_urls SET website = $2, classification = $3, status = $4, fetched_at = $5
+	WHERE url = $1
+`
+
+// Insert the first fetch of a page.
+const insertSeenURLSQL = `
+	INSERT INTO seen_urls (url, website, classification, status, fetched_at)
+	VALUES ($1, $2, $3, $4, $5)
+`
+
+type seenURLsStatements struct {
+	selectSeenURLsForWebsiteSinceStmt *sql.Stmt
+	updateSeenURLStmt                 *sql.Stmt
+	insertSeenURLStmt                 *sql.Stmt
+}
+
+// Create the table if it doesn't exist and prepare the SQL statements.
+func (s *seenURLsStatements) prepare(db *sql.DB) (err error) {
+	_, err = db.Exec(seenURLsSchema)
+	if err != nil {
+		return
+	}
+	if s.selectSeenURLsForWebsiteSinceStmt, err = db.Prepare(selectSeenURLsForWebsiteSinceSQL); err != nil {
+		return
+	}
+	if s.updateSeenURLStmt, err = db.Prepare(updateSeenURLSQL); err != nil {
+		return
+	}
+	if s.insertSeenURLStmt, err = db.Prepare(insertSeenURLSQL); err != nil {
+		return
+	}
+	return
+}
+
+// upsertSeenURL inserts the last fetch of a page into the database, or updates
+// it if the page was already fetched before. An update followed by an insertion
+// is used instead of an upsert statement, since not all supported versions of
+// SQLite implement it.
+// Returns an error if there was an issue updating or inserting the fetch.
+func (s *seenURLsStatements) upsertSeenURL(website string, seen *common.SeenURL) (err error) {
+	args := []interface{}{
+		seen.URL, website, seen.Classification, seen.StatusCode, seen.FetchedAt.UTC(),
+	}
+
+	res, err := s.updateSeenURLStmt.Exec(args...)
+	if err != nil {
+		return
+	}
+	if updated, err := res.RowsAffected(); err != nil || updated > 0 {
+		return err
+	}
+
+	_, err = s.insertSeenURLStmt.Exec(args...)
+	return
+}
+
+// selectSeenURLsForWebsiteSince returns the URLs of the pages of a given website
+// that were fetched since a given date, except listing pages.
+// Returns an error if there was an issue performing the query or reading the
+// rows it returned.
+func (s *seenURLsStatements) selectSeenURLsForWebsiteSince(
+	website string, since time.Time,
+) (urls map[string]bool, err error) {
+	rows, err := s.selectSeenURLsForWebsiteSinceStmt.Query(website, common.PageListing, since.UTC())
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	urls = make(map[string]bool)
+	var u string
+	for rows.Next() {
+		if err = rows.Scan(&u); err != nil {
+			return
+		}
+		urls[u] = true
+	}
+
+	return urls, rows.Err()
+}
@@ -0,0 +1,41 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"testing"
+)
+
+func TestNormaliseLanguageTag(t *testing.T) {
+	tests := map[string]string{
+		"fr":     "fr",
+		"FR":     "fr",
+		"fr-FR":  "fr",
+		"fr_FR":  "fr",
+		" en ":   "en",
+		"ast-ES": "ast",
+		"x":      "",
+		"fren":   "",
+		"f1":     "",
+		"":       "",
+	}
+
+	for tag, want := range tests {
+		if got := NormaliseLanguageTag(tag); got != want {
+			t.Errorf("NormaliseLanguageTag(%q) = %q, want %q", tag, got, want)
+		}
+	}
+}
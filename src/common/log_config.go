@@ -16,9 +16,34 @@
 package common
 
 import (
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"common/config"
+
 	"github.com/sirupsen/logrus"
 )
 
+// logTimestampFormat is the format of the logs' timestamps.
+const logTimestampFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+var (
+	// componentLoggers contains a logger for each of the components listed in
+	// config.LogComponents, so each component can log with its own level.
+	componentLoggers = make(map[string]*logrus.Logger)
+	// logFile is the file logs are currently written to, if any.
+	logFile     io.Closer
+	loggersLock sync.Mutex
+)
+
+func init() {
+	for _, component := range config.LogComponents {
+		componentLoggers[component] = logrus.New()
+	}
+}
+
 // utcFormatter implements logrus.Formatter
 type utcFormatter struct {
 	logrus.Formatter
@@ -30,21 +55,111 @@ func (f utcFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	return f.Formatter.Format(entry)
 }
 
-// LogConfig configures the logrus logger by setting the formatter to an instance
-// of utcFormatter and setting the log level accordingly with the command line
-// arguments.
-func LogConfig(debug bool) {
-	if debug {
-		logrus.SetLevel(logrus.DebugLevel)
+// Logger returns the logger to use in a given component, which must be one of
+// the components listed in config.LogComponents. If it isn't, logrus' standard
+// logger is returned.
+func Logger(component string) *logrus.Logger {
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+
+	if logger, ok := componentLoggers[component]; ok {
+		return logger
+	}
+
+	return logrus.StandardLogger()
+}
+
+// LogConfig configures logrus' standard logger and the components' loggers by
+// setting the formatter to an instance of utcFormatter wrapping either a text or
+// a JSON formatter, setting the output and setting the log level accordingly with
+// the logging configuration and the command line arguments. The debug level is
+// used for all loggers if debug is true. If no configuration is provided (e.g.
+// because it hasn't been loaded yet), logs are written as text to the standard
+// error with the info level.
+// Returns an error if the configured log file couldn't be opened, or if one of
+// the configured levels is invalid.
+func LogConfig(cfg *config.LoggingConfig, debug bool) error {
+	if cfg == nil {
+		cfg = new(config.LoggingConfig)
 	}
 
-	logrus.SetFormatter(&utcFormatter{
+	var formatter logrus.Formatter = &utcFormatter{
 		&logrus.TextFormatter{
-			TimestampFormat:  "2006-01-02T15:04:05.000000000Z07:00",
+			TimestampFormat:  logTimestampFormat,
 			FullTimestamp:    true,
 			DisableColors:    false,
 			DisableTimestamp: false,
 			DisableSorting:   false,
 		},
-	})
+	}
+	if strings.ToLower(cfg.Format) == "json" {
+		formatter = &utcFormatter{
+			&logrus.JSONFormatter{TimestampFormat: logTimestampFormat},
+		}
+	}
+
+	// Parse the levels before changing anything.
+	level, err := logLevel(cfg.Level, debug)
+	if err != nil {
+		return err
+	}
+	componentLevels := make(map[string]logrus.Level)
+	for component, l := range cfg.Components {
+		if componentLevels[component], err = logLevel(l, debug); err != nil {
+			return err
+		}
+	}
+
+	// Open the log file if one is configured.
+	var output io.Writer = os.Stderr
+	var file *rotatingFile
+	if cfg.File != nil {
+		if file, err = newRotatingFile(cfg.File); err != nil {
+			return err
+		}
+		output = file
+	}
+
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+
+	// Close the file logs were written to before, if any.
+	if logFile != nil {
+		logFile.Close()
+		logFile = nil
+	}
+	if file != nil {
+		logFile = file
+	}
+
+	logrus.SetFormatter(formatter)
+	logrus.SetOutput(output)
+	logrus.SetLevel(level)
+
+	for component, logger := range componentLoggers {
+		componentLevel, ok := componentLevels[component]
+		if !ok {
+			componentLevel = level
+		}
+
+		logger.Formatter = formatter
+		logger.Out = output
+		logger.Level = componentLevel
+	}
+
+	return nil
+}
+
+// logLevel parses a log level, which defaults to the info level if empty. If
+// debug is true, the debug level is used whatever the given level is.
+// Returns an error if the level is invalid.
+func logLevel(level string, debug bool) (logrus.Level, error) {
+	if debug {
+		return logrus.DebugLevel, nil
+	}
+	if len(level) == 0 {
+		return logrus.InfoLevel, nil
+	}
+
+	return logrus.ParseLevel(level)
 }
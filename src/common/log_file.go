@@ -0,0 +1,133 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"common/config"
+)
+
+// defaultLogFileMaxSize is the size, in megabytes, after which the log file is
+// rotated if no maximum size is provided in the configuration.
+const defaultLogFileMaxSize = 100
+
+// defaultLogFileMaxBackups is the number of rotated log files kept if no number
+// is provided in the configuration.
+const defaultLogFileMaxBackups = 5
+
+// rotatingFile is an io.Writer writing to a file, which is rotated once it
+// reaches a given size: the file is renamed by appending ".1" to its name, the
+// previous ".1" file is renamed with ".2", and so on, up to a given number of
+// files, after which the oldest file is removed. It is safe to use from several
+// goroutines.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	lock       sync.Mutex
+	file       *os.File
+	size       int64
+}
+
+// newRotatingFile opens the log file described by the given configuration, in
+// append mode.
+// Returns an error if the file couldn't be opened.
+func newRotatingFile(cfg *config.LogFileConfig) (*rotatingFile, error) {
+	maxSize := cfg.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultLogFileMaxSize
+	}
+	maxBackups := cfg.MaxBackups
+	if maxBackups <= 0 {
+		maxBackups = defaultLogFileMaxBackups
+	}
+
+	f := &rotatingFile{
+		path:       cfg.Path,
+		maxSize:    maxSize * 1024 * 1024,
+		maxBackups: maxBackups,
+	}
+
+	return f, f.open()
+}
+
+// open opens the log file in append mode, creating it if needed.
+// Returns an error if the file couldn't be opened or if its size couldn't be
+// retrieved.
+func (f *rotatingFile) open() (err error) {
+	if f.file, err = os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err != nil {
+		return
+	}
+
+	info, err := f.file.Stat()
+	if err != nil {
+		return
+	}
+	f.size = info.Size()
+
+	return
+}
+
+// Write implements io.Writer.Write
+// Rotates the file before writing if the write would make it exceed its
+// maximum size.
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close implements io.Closer.Close
+func (f *rotatingFile) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.file.Close()
+}
+
+// rotate closes the current file, shifts the rotated files, and opens a new
+// file. Must be called with the file's lock held.
+// Returns an error if closing, renaming or opening a file failed.
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	// Shift the rotated files, starting with the oldest one, which is removed
+	// if it exists. Errors are ignored here since some of the rotated files
+	// might not exist yet.
+	os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxBackups))
+	for i := f.maxBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+	}
+	if err := os.Rename(f.path, f.path+".1"); err != nil {
+		return err
+	}
+
+	return f.open()
+}
@@ -0,0 +1,303 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics implements a minimal set of Prometheus metric types (counters
+// and histograms, both with labels) and serves them using Prometheus' text
+// exposition format.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// labelValueEscaper escapes the characters Prometheus requires to be escaped in
+// labels' values.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// defaultRegistry is the registry all metrics created with NewCounter and
+// NewHistogram are added to, and which is served by Handler.
+var defaultRegistry = &registry{}
+
+// metric describes a metric that can be written using Prometheus' text
+// exposition format.
+type metric interface {
+	write(w io.Writer)
+}
+
+// registry represents a set of metrics served together.
+type registry struct {
+	lock    sync.Mutex
+	metrics []metric
+}
+
+// register adds a metric to the registry.
+func (r *registry) register(m metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.metrics = append(r.metrics, m)
+}
+
+// ServeHTTP implements http.Handler.ServeHTTP
+// Writes all of the registry's metrics using Prometheus' text exposition format.
+func (r *registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.lock.Lock()
+	metrics := make([]metric, len(r.metrics))
+	copy(metrics, r.metrics)
+	r.lock.Unlock()
+
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		m.write(&buf)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+// Handler returns an http.Handler serving all metrics created with NewCounter
+// and NewHistogram.
+func Handler() http.Handler {
+	return defaultRegistry
+}
+
+// desc contains the name, description and label names of a metric.
+type desc struct {
+	name       string
+	help       string
+	labelNames []string
+}
+
+// key computes the key identifying a series from its labels' values.
+// Panics if the number of values doesn't match the number of labels, since it
+// can only be caused by a programming error.
+func (d *desc) key(labelValues []string) string {
+	if len(labelValues) != len(d.labelNames) {
+		panic(fmt.Sprintf(
+			"metrics: %s expects %d label values, got %d",
+			d.name, len(d.labelNames), len(labelValues),
+		))
+	}
+
+	return strings.Join(labelValues, "\xff")
+}
+
+// labels formats the labels of a series, with additional labels appended to
+// them, e.g. {website="foo",le="0.5"}.
+func (d *desc) labels(labelValues []string, extra ...string) string {
+	pairs := make([]string, 0, len(labelValues)+len(extra)/2)
+	for i, name := range d.labelNames {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelValueEscaper.Replace(labelValues[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], labelValueEscaper.Replace(extra[i+1])))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// header writes the HELP and TYPE lines of a metric.
+func (d *desc) header(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, strings.Replace(d.help, "\n", " ", -1))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, kind)
+}
+
+// sortedKeys returns the keys of a map of series, sorted so the output is
+// stable between two scrapes.
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// formatFloat formats a value the way Prometheus expects it.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter represents a Prometheus counter, i.e. a value that can only increase,
+// with one series for each combination of its labels' values.
+type Counter struct {
+	desc
+	lock        sync.Mutex
+	labelValues map[string][]string
+	values      map[string]float64
+}
+
+// NewCounter creates a new counter with the given name, description and label
+// names, and registers it so it is served by Handler.
+func NewCounter(name string, help string, labelNames ...string) *Counter {
+	c := &Counter{
+		desc:        desc{name: name, help: help, labelNames: labelNames},
+		labelValues: make(map[string][]string),
+		values:      make(map[string]float64),
+	}
+	defaultRegistry.register(c)
+
+	return c
+}
+
+// Inc increments by 1 the series identified by the given label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases by v the series identified by the given label values. Negative
+// values are ignored, since a counter can't decrease.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+
+	key := c.key(labelValues)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, exists := c.labelValues[key]; !exists {
+		c.labelValues[key] = append([]string(nil), labelValues...)
+	}
+	c.values[key] += v
+}
+
+// write implements metric.write
+func (c *Counter) write(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.header(w, "counter")
+	for _, key := range sortedKeys(c.labelValues) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labels(c.labelValues[key]), formatFloat(c.values[key]))
+	}
+}
+
+// DefaultBuckets are the default upper bounds of a histogram's buckets, adapted
+// to measuring durations in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram represents a Prometheus histogram, i.e. a distribution of observed
+// values, with one series for each combination of its labels' values.
+type Histogram struct {
+	desc
+	buckets     []float64
+	lock        sync.Mutex
+	labelValues map[string][]string
+	series      map[string]*histogramSeries
+}
+
+// histogramSeries contains the observations of a single series of a histogram.
+type histogramSeries struct {
+	// counts contains the number of observations in each bucket, i.e. lower
+	// than or equal to its upper bound but greater than the previous one's.
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram creates a new histogram with the given name, description, upper
+// bounds for its buckets (DefaultBuckets if nil) and label names, and registers
+// it so it is served by Handler.
+func NewHistogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+
+	h := &Histogram{
+		desc:        desc{name: name, help: help, labelNames: labelNames},
+		buckets:     sorted,
+		labelValues: make(map[string][]string),
+		series:      make(map[string]*histogramSeries),
+	}
+	defaultRegistry.register(h)
+
+	return h
+}
+
+// Observe adds an observation to the series identified by the given label
+// values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	s, exists := h.series[key]
+	if !exists {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+		h.labelValues[key] = append([]string(nil), labelValues...)
+	}
+
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// write implements metric.write
+// Buckets are cumulative, as Prometheus expects them.
+func (h *Histogram) write(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.header(w, "histogram")
+	for _, key := range sortedKeys(h.labelValues) {
+		labelValues := h.labelValues[key]
+		s := h.series[key]
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(
+				w, "%s_bucket%s %d\n",
+				h.name, h.labels(labelValues, "le", formatFloat(bound)), cumulative,
+			)
+		}
+		fmt.Fprintf(
+			w, "%s_bucket%s %d\n", h.name, h.labels(labelValues, "le", "+Inf"), s.count,
+		)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labels(labelValues), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labels(labelValues), s.count)
+	}
+}
@@ -12,4 +12,100 @@ type Article struct {
 	Content     string
 	Author      *string
 	Date        time.Time
+	Language    *string
+	// ID of the WARC record containing the HTTP response the article was
+	// extracted from, if the website's responses are archived.
+	WARCRecordID *string
+	// SimHash fingerprint of the article's text, if it is long enough for it
+	// to be meaningful.
+	Fingerprint *uint64
+	// Date and time at which the article was crawled, as opposed to Date,
+	// which is the one at which it was published.
+	CrawledAt time.Time
+}
+
+// ArticleFingerprint describes the fingerprint of an article's text, along with
+// the URL of the article it is a near-duplicate of, if any.
+type ArticleFingerprint struct {
+	URL         string
+	Fingerprint uint64
+	// URL of the first saved article of the group of near-duplicates the
+	// article belongs to. Empty if the article isn't a near-duplicate.
+	Original string
+}
+
+// PageValidators describes the validators (i.e. the ETag and Last-Modified
+// headers) a website sent along with a page, which can be used to request the
+// page again only if it changed, along with the links found in the page, which
+// are followed again if it didn't.
+type PageValidators struct {
+	URL          string
+	ETag         string
+	LastModified string
+	Links        []string
+}
+
+// Classifications of the pages that aren't articles.
+const (
+	// PageListing is a page linking to articles (e.g. the home page or a
+	// section), through which new articles can be found.
+	PageListing = "listing"
+	// PageOther is a page that neither is an article nor links to any (e.g.
+	// an "about" or "contact" page).
+	PageOther = "other"
+	// PageError is a page the website responded to with a client error status
+	// code (e.g. 404).
+	PageError = "error"
+)
+
+// SeenURL describes the last time a page that isn't an article was fetched,
+// along with how it was classified and the status code of the response.
+type SeenURL struct {
+	URL            string
+	Classification string
+	StatusCode     int
+	FetchedAt      time.Time
+}
+
+// CrawlRun describes the extraction statistics of a single run of the crawler
+// on a website.
+type CrawlRun struct {
+	Website   string
+	StartedAt time.Time
+	EndedAt   time.Time
+	// Number of pages visited (i.e. fetched and parsed) during the run.
+	PagesVisited int
+	// Number of visited pages expected to be articles, i.e. which URL matches
+	// the website's article patterns, or looks like the URL of an article if
+	// it has none. Only these pages are taken into account when computing the
+	// selectors' match rates.
+	ArticlePagesVisited int
+	// Number of articles extracted from the visited pages and saved.
+	ArticlesSaved int
+	// Number of articles which date couldn't be parsed.
+	DateParseFailures int
+	// Number of visited pages expected to be articles in which each of the
+	// website's selectors matched at least one node, identified by their name
+	// in the configuration file.
+	SelectorMatches map[string]int
+	// Whether the selectors' match rates dropped sharply compared to the
+	// previous runs on the same website, which usually means its selectors are
+	// broken.
+	Degraded bool
+}
+
+// MatchRates returns the ratio of visited pages expected to be articles each
+// of the website's selectors matched in. Returns an empty map if no such page
+// was visited.
+func (r *CrawlRun) MatchRates() map[string]float64 {
+	rates := make(map[string]float64, len(r.SelectorMatches))
+	if r.ArticlePagesVisited == 0 {
+		return rates
+	}
+
+	for name, matches := range r.SelectorMatches {
+		rates[name] = float64(matches) / float64(r.ArticlePagesVisited)
+	}
+
+	return rates
 }
@@ -0,0 +1,58 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"time"
+
+	"common/config"
+)
+
+// Default maximum number of links followed from an archive page in backfill
+// mode.
+const defaultBackfillMaxDepth = 1
+
+// archivePages returns the URLs of the archive pages of a website for each day
+// of a backfill range, from the most recent day to the oldest one. Templates
+// that don't contain the day (e.g. monthly archives) give the same URL for
+// several days, which is only returned once.
+func archivePages(cfg *config.BackfillConfig, r *config.BackfillRange) (urls []string) {
+	since := time.Date(r.Since.Year(), r.Since.Month(), r.Since.Day(), 0, 0, 0, 0, time.UTC)
+	day := time.Date(r.Until.Year(), r.Until.Month(), r.Until.Day(), 0, 0, 0, 0, time.UTC)
+
+	seen := make(map[string]bool)
+	for ; !day.Before(since); day = day.AddDate(0, 0, -1) {
+		for _, template := range cfg.ArchiveURLs {
+			u := config.FormatLayoutPatterns(template, day)
+			if !seen[u] {
+				seen[u] = true
+				urls = append(urls, u)
+			}
+		}
+	}
+
+	return
+}
+
+// backfillMaxDepth returns the maximum number of links followed from an archive
+// page of a website in backfill mode.
+func backfillMaxDepth(cfg *config.BackfillConfig) int {
+	if cfg.MaxDepth > 0 {
+		return cfg.MaxDepth
+	}
+
+	return defaultBackfillMaxDepth
+}
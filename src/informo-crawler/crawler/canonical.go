@@ -0,0 +1,166 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/PuerkitoBio/purell"
+)
+
+const (
+	// canonicalURLFlags are the flags used to normalize the URL articles are
+	// saved under. They only include transformations that don't prevent the
+	// URL from leading to the article.
+	canonicalURLFlags = purell.FlagsUsuallySafeGreedy | purell.FlagRemoveFragment |
+		purell.FlagRemoveDuplicateSlashes
+	// articleKeyFlags are the flags used to normalize URLs when looking for
+	// articles that were already saved. They also include transformations that
+	// can prevent the URL from leading to the article, such as removing the
+	// "www." prefix or forcing the scheme, but make variants of the same URL
+	// identical.
+	articleKeyFlags = canonicalURLFlags | purell.FlagRemoveWWW | purell.FlagForceHTTP |
+		purell.FlagRemoveDirectoryIndex | purell.FlagSortQuery
+)
+
+// ampQueryKeys are the query keys some websites use to serve the AMP version of
+// their pages.
+var ampQueryKeys = []string{"amp", "_amp", "outputType"}
+
+// CanonicalURL returns the canonical URL of a page, i.e. the URL declared by its
+// <link rel="canonical"> element if it has one, or else the page's URL, which
+// is mapped back to the URL of the regular version of the page if it is the URL
+// of its AMP version (see nonAMPURL). In both cases, the URL is normalized. A
+// canonical URL leading to the website's home page is ignored, since it is
+// usually a mistake when declared by an article.
+func CanonicalURL(pageURL *url.URL, doc *goquery.Document) string {
+	canonical := nonAMPURL(pageURL)
+
+	if href, ok := doc.Find(`link[rel="canonical"]`).First().Attr("href"); ok {
+		u, err := pageURL.Parse(strings.TrimSpace(href))
+		if err == nil && (u.Scheme == "http" || u.Scheme == "https") &&
+			strings.Trim(u.Path, "/") != "" {
+			canonical = u
+		}
+	}
+
+	return purell.NormalizeURL(copyURL(canonical), canonicalURLFlags)
+}
+
+// articleKey returns the key identifying the article at a given URL, which is
+// the same for all the variants of the URL (see articleKeyFlags and nonAMPURL).
+// If the URL can't be parsed, it is used as the key.
+func articleKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	return purell.NormalizeURL(nonAMPURL(u), articleKeyFlags)
+}
+
+// nonAMPURL returns a copy of a URL without the parts that identify the AMP
+// version of a page, i.e. an "amp." host prefix, an "amp" path segment, an
+// ".amp" extension, or an AMP query key. If the URL isn't the URL of an AMP
+// page, an identical copy is returned.
+func nonAMPURL(u *url.URL) *url.URL {
+	c := copyURL(u)
+
+	c.Host = strings.TrimPrefix(c.Host, "amp.")
+
+	segments := strings.Split(c.Path, "/")
+	kept := segments[:0]
+	for i, segment := range segments {
+		// Keep the leading and trailing empty segments, so the path keeps its
+		// slashes.
+		if segment == "amp" && i > 0 {
+			continue
+		}
+		kept = append(kept, segment)
+	}
+	c.Path = strings.Join(kept, "/")
+
+	if ext := path.Ext(c.Path); strings.HasSuffix(strings.TrimSuffix(c.Path, ext), ".amp") {
+		c.Path = strings.TrimSuffix(strings.TrimSuffix(c.Path, ext), ".amp") + ext
+	} else if ext == ".amp" {
+		c.Path = strings.TrimSuffix(c.Path, ext)
+	}
+
+	if len(c.RawQuery) > 0 {
+		q := c.Query()
+		for _, key := range ampQueryKeys {
+			if key == "outputType" && q.Get(key) != "amp" {
+				continue
+			}
+			q.Del(key)
+		}
+		c.RawQuery = q.Encode()
+	}
+	c.RawPath = ""
+
+	return c
+}
+
+// copyURL returns a copy of a URL, which can be modified without modifying the
+// original URL.
+func copyURL(u *url.URL) *url.URL {
+	c := *u
+	if u.User != nil {
+		user := *u.User
+		c.User = &user
+	}
+
+	return &c
+}
+
+// articleSet is a set of articles, identified by their key (see articleKey). It
+// is safe to use from several goroutines.
+type articleSet struct {
+	lock sync.RWMutex
+	keys map[string]bool
+}
+
+// newArticleSet instantiates a new set containing the articles at the given
+// URLs.
+func newArticleSet(urls map[string]bool) *articleSet {
+	s := &articleSet{keys: make(map[string]bool, len(urls))}
+	for u := range urls {
+		s.keys[articleKey(u)] = true
+	}
+
+	return s
+}
+
+// has checks whether the article at the given URL is in the set, whatever the
+// variant of its URL.
+func (s *articleSet) has(u string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.keys[articleKey(u)]
+}
+
+// add adds the article at the given URL to the set.
+func (s *articleSet) add(u string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.keys[articleKey(u)] = true
+}
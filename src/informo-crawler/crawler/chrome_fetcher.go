@@ -0,0 +1,481 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"common/config"
+
+	"golang.org/x/net/websocket"
+)
+
+// defaultRenderTimeout is the maximum time a page can take to be rendered if
+// no timeout is provided in the configuration.
+const defaultRenderTimeout = 30 * time.Second
+
+// renderSlots limits the number of pages being rendered at the same time across
+// all crawlers, since rendering a page is far more expensive than fetching it.
+// It is initialised with the first ChromeFetcher, with a capacity defined in the
+// configuration.
+var (
+	renderSlots     chan struct{}
+	renderSlotsOnce sync.Once
+)
+
+// ChromeFetcher implements Fetcher by rendering pages in a headless Chrome (or
+// Chromium) browser, driven through the Chrome DevTools Protocol. This allows
+// crawling websites which pages are generated by JavaScript code, and therefore
+// are mostly empty when retrieved with a plain HTTP request.
+// robots.txt files and HEAD requests are delegated to a fallback fetcher. The
+// browser sends the headers and the cookies of the website's session, if any,
+// and the cookies it receives are added to the session.
+type ChromeFetcher struct {
+	endpoint *url.URL
+	timeout  time.Duration
+	client   *http.Client
+	session  *Session
+	fallback Fetcher
+}
+
+// NewChromeFetcher instantiates a new ChromeFetcher using the given renderer
+// configuration, the session of the website being crawled, which can be nil,
+// and the fetcher to use for robots.txt files and HEAD requests.
+// Returns an error if no renderer is configured, or if the configured endpoint
+// isn't a valid URL.
+func NewChromeFetcher(
+	cfg *config.RendererConfig, session *Session, fallback Fetcher,
+) (*ChromeFetcher, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("Website requires rendering but no renderer is configured")
+	}
+
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid renderer endpoint: %v", err)
+	}
+
+	timeout := cfg.Timeout * time.Second
+	if timeout <= 0 {
+		timeout = defaultRenderTimeout
+	}
+
+	renderSlotsOnce.Do(func() {
+		if cfg.MaxConcurrency > 0 {
+			renderSlots = make(chan struct{}, cfg.MaxConcurrency)
+		}
+	})
+
+	return &ChromeFetcher{
+		endpoint: endpoint,
+		timeout:  timeout,
+		client:   &http.Client{Timeout: timeout},
+		session:  session,
+		fallback: fallback,
+	}, nil
+}
+
+// cdpTarget represents a target (i.e. a browser tab) as described by the
+// DevTools HTTP endpoint.
+type cdpTarget struct {
+	ID                   string `json:"id"`
+	WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+}
+
+// cdpMessage represents a message sent by the browser over the DevTools
+// Protocol, which can either be the response to a command (in which case ID is
+// set) or an event (in which case Method is set).
+type cdpMessage struct {
+	ID     int             `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// cdpCommand represents a command sent to the browser over the DevTools
+// Protocol.
+type cdpCommand struct {
+	ID     int         `json:"id"`
+	Method string      `json:"method"`
+	Params interface{} `json:"params,omitempty"`
+}
+
+// Fetch implements Fetcher.Fetch
+// Opens a new tab in the browser, loads the page in it, waits for it to be
+// loaded, and returns a response which body is the page's DOM serialised as
+// HTML. The response's status code and headers are the ones sent by the website
+// when the page was loaded.
+// Returns an error if communicating with the browser failed, if the browser
+// couldn't load the page, or if the page took too long to load.
+func (f *ChromeFetcher) Fetch(u *url.URL, userAgent string, headRequest bool) (*http.Response, error) {
+	if headRequest || isRobotsURL(u) {
+		return f.fallback.Fetch(u, userAgent, headRequest)
+	}
+
+	// Wait for a rendering slot to be available.
+	if renderSlots != nil {
+		renderSlots <- struct{}{}
+		defer func() { <-renderSlots }()
+	}
+
+	// Open a new tab, and make sure it's closed once we're done with it.
+	target, err := f.newTarget()
+	if err != nil {
+		return nil, err
+	}
+	defer f.closeTarget(target)
+
+	ws, err := websocket.Dial(target.WebSocketDebuggerURL, "", f.endpoint.String())
+	if err != nil {
+		return nil, err
+	}
+	defer ws.Close()
+
+	// The whole rendering process must take less than the timeout.
+	if err = ws.SetDeadline(time.Now().Add(f.timeout)); err != nil {
+		return nil, err
+	}
+
+	s := &cdpSession{ws: ws}
+	if err = s.send("Network.setUserAgentOverride", map[string]string{"userAgent": userAgent}); err != nil {
+		return nil, err
+	}
+	if err = s.send("Network.enable", nil); err != nil {
+		return nil, err
+	}
+	if err = f.setSession(s, u); err != nil {
+		return nil, err
+	}
+	if err = s.send("Page.enable", nil); err != nil {
+		return nil, err
+	}
+
+	// Ask the browser to load the page, and wait for it to be loaded.
+	var navigation struct {
+		FrameID   string `json:"frameId"`
+		ErrorText string `json:"errorText"`
+	}
+	if err = s.call("Page.navigate", map[string]string{"url": u.String()}, &navigation); err != nil {
+		return nil, err
+	}
+	if len(navigation.ErrorText) > 0 {
+		return nil, fmt.Errorf("Browser couldn't load the page: %s", navigation.ErrorText)
+	}
+	if err = s.waitFor("Page.loadEventFired"); err != nil {
+		return nil, err
+	}
+
+	// Retrieve the page's DOM, serialised as HTML.
+	var evaluation struct {
+		Result struct {
+			Value string `json:"value"`
+		} `json:"result"`
+	}
+	if err = s.call("Runtime.evaluate", map[string]interface{}{
+		"expression":    "document.documentElement.outerHTML",
+		"returnByValue": true,
+	}, &evaluation); err != nil {
+		return nil, err
+	}
+
+	// Keep the cookies the website set while the page was loading.
+	if err = f.saveCookies(s, u); err != nil {
+		return nil, err
+	}
+
+	// Build the response from the status and the headers the main document was
+	// served with.
+	res := &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          ioutil.NopCloser(strings.NewReader(evaluation.Result.Value)),
+		ContentLength: int64(len(evaluation.Result.Value)),
+	}
+	if doc := s.documentResponse(navigation.FrameID); doc != nil {
+		res.StatusCode = doc.Status
+		res.Status = fmt.Sprintf("%d %s", doc.Status, http.StatusText(doc.Status))
+		for k, v := range doc.Headers {
+			res.Header.Set(k, v)
+		}
+	}
+	// The body is the serialised DOM, which isn't compressed and is always
+	// encoded in UTF-8, whatever the original response was.
+	res.Header.Del("Content-Encoding")
+	res.Header.Del("Content-Length")
+	res.Header.Set("Content-Type", "text/html; charset=utf-8")
+
+	if res.Request, err = http.NewRequest("GET", u.String(), nil); err != nil {
+		return nil, err
+	}
+	res.Request.Header.Set("User-Agent", userAgent)
+
+	return res, nil
+}
+
+// cdpCookie represents a cookie as described by the DevTools Protocol.
+type cdpCookie struct {
+	Name     string  `json:"name"`
+	Value    string  `json:"value"`
+	URL      string  `json:"url,omitempty"`
+	Domain   string  `json:"domain,omitempty"`
+	Path     string  `json:"path,omitempty"`
+	Expires  float64 `json:"expires,omitempty"`
+	HTTPOnly bool    `json:"httpOnly,omitempty"`
+	Secure   bool    `json:"secure,omitempty"`
+}
+
+// setSession makes the browser send the session's headers and cookies along
+// with the requests it sends to load the page at a given URL.
+// Returns an error if the browser responded to one of the commands with an
+// error.
+func (f *ChromeFetcher) setSession(s *cdpSession, u *url.URL) error {
+	if f.session == nil {
+		return nil
+	}
+
+	if len(f.session.headers) > 0 {
+		headers := make(map[string]string)
+		for name := range f.session.headers {
+			headers[name] = strings.Join(f.session.headers[name], ", ")
+		}
+		if err := s.send("Network.setExtraHTTPHeaders", map[string]interface{}{
+			"headers": headers,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if f.session.jar == nil {
+		return nil
+	}
+	var cookies []cdpCookie
+	for _, cookie := range f.session.jar.Cookies(u) {
+		cookies = append(cookies, cdpCookie{Name: cookie.Name, Value: cookie.Value, URL: u.String()})
+	}
+	if len(cookies) == 0 {
+		return nil
+	}
+
+	return s.send("Network.setCookies", map[string]interface{}{"cookies": cookies})
+}
+
+// saveCookies adds the cookies the browser has for the page at a given URL to
+// the session's cookie jar, if the session keeps cookies.
+// Returns an error if the browser responded with an error.
+func (f *ChromeFetcher) saveCookies(s *cdpSession, u *url.URL) error {
+	if f.session == nil || f.session.jar == nil {
+		return nil
+	}
+
+	var result struct {
+		Cookies []cdpCookie `json:"cookies"`
+	}
+	if err := s.call("Network.getCookies", map[string]interface{}{
+		"urls": []string{u.String()},
+	}, &result); err != nil {
+		return err
+	}
+
+	cookies := make([]*http.Cookie, 0, len(result.Cookies))
+	for _, c := range result.Cookies {
+		cookie := &http.Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			HttpOnly: c.HTTPOnly,
+			Secure:   c.Secure,
+		}
+		// The domain of host-only cookies doesn't start with a dot. Since only
+		// the cookies sent to the page's host are retrieved, it's the host of
+		// these cookies.
+		if strings.HasPrefix(c.Domain, ".") {
+			cookie.Domain = c.Domain
+		}
+		// Session cookies have an expiry date of -1.
+		if c.Expires > 0 {
+			cookie.Expires = time.Unix(int64(c.Expires), 0)
+		}
+		cookies = append(cookies, cookie)
+	}
+	f.session.jar.SetCookies(u, cookies)
+
+	return nil
+}
+
+// newTarget opens a new tab in the browser.
+// Returns an error if the request to the browser's DevTools HTTP endpoint failed
+// or if its response couldn't be decoded.
+func (f *ChromeFetcher) newTarget() (*cdpTarget, error) {
+	// Recent versions of Chrome require the PUT method to be used to open a new
+	// tab.
+	req, err := http.NewRequest("PUT", f.endpoint.ResolveReference(&url.URL{Path: "/json/new"}).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Couldn't open a new tab in the browser: %s", res.Status)
+	}
+
+	target := new(cdpTarget)
+	if err = json.NewDecoder(res.Body).Decode(target); err != nil {
+		return nil, err
+	}
+
+	return target, nil
+}
+
+// closeTarget closes a tab in the browser. Errors are ignored, because there's
+// not much we can do about them.
+func (f *ChromeFetcher) closeTarget(target *cdpTarget) {
+	res, err := f.client.Get(
+		f.endpoint.ResolveReference(&url.URL{Path: "/json/close/" + target.ID}).String(),
+	)
+	if err == nil {
+		res.Body.Close()
+	}
+}
+
+// cdpDocumentResponse represents the part of a Network.responseReceived event
+// we need to rebuild the response to the main document's request.
+type cdpDocumentResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+}
+
+// cdpSession represents a connection to a tab in the browser, and keeps track
+// of the events received from it.
+type cdpSession struct {
+	ws     *websocket.Conn
+	lastID int
+	events []cdpMessage
+}
+
+// send sends a command to the browser and waits for its completion, ignoring
+// its result.
+// Returns an error if sending the command failed, or if the browser responded
+// with an error.
+func (s *cdpSession) send(method string, params interface{}) error {
+	return s.call(method, params, nil)
+}
+
+// call sends a command to the browser and waits for its completion. If result
+// isn't nil, the command's result is decoded into it. Events received while
+// waiting are stored to be processed later.
+// Returns an error if sending the command or reading the browser's messages
+// failed, if the result couldn't be decoded, or if the browser responded with
+// an error.
+func (s *cdpSession) call(method string, params interface{}, result interface{}) error {
+	s.lastID++
+	id := s.lastID
+	if err := websocket.JSON.Send(s.ws, cdpCommand{ID: id, Method: method, Params: params}); err != nil {
+		return err
+	}
+
+	for {
+		var msg cdpMessage
+		if err := websocket.JSON.Receive(s.ws, &msg); err != nil {
+			return err
+		}
+
+		if msg.ID != id {
+			if len(msg.Method) > 0 {
+				s.events = append(s.events, msg)
+			}
+			continue
+		}
+
+		if msg.Error != nil {
+			return fmt.Errorf("%s failed: %s", method, msg.Error.Message)
+		}
+		if result != nil {
+			return json.Unmarshal(msg.Result, result)
+		}
+
+		return nil
+	}
+}
+
+// waitFor waits until an event with the given method is received from the
+// browser, unless it has already been received.
+// Returns an error if reading the browser's messages failed.
+func (s *cdpSession) waitFor(method string) error {
+	for _, event := range s.events {
+		if event.Method == method {
+			return nil
+		}
+	}
+
+	for {
+		var msg cdpMessage
+		if err := websocket.JSON.Receive(s.ws, &msg); err != nil {
+			return err
+		}
+
+		s.events = append(s.events, msg)
+		if msg.Method == method {
+			return nil
+		}
+	}
+}
+
+// documentResponse looks in the received events for the response to the
+// request for the document loaded in a given frame.
+// Returns nil if no such response was received.
+func (s *cdpSession) documentResponse(frameID string) *cdpDocumentResponse {
+	for _, event := range s.events {
+		if event.Method != "Network.responseReceived" {
+			continue
+		}
+
+		var params struct {
+			Type     string              `json:"type"`
+			FrameID  string              `json:"frameId"`
+			Response cdpDocumentResponse `json:"response"`
+		}
+		if err := json.Unmarshal(event.Params, &params); err != nil {
+			continue
+		}
+
+		// Redirections aren't reported as responses, so the first response
+		// for a document in the frame is the one we're looking for.
+		if params.Type == "Document" && params.FrameID == frameID {
+			return &params.Response
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"common/config"
+
+	"golang.org/x/net/websocket"
+)
+
+// fakeBrowser is a stand-in for a headless browser's DevTools endpoint, which
+// renders every page as the same HTML document, and records the commands it
+// receives.
+type fakeBrowser struct {
+	server   *httptest.Server
+	html     string
+	status   int
+	cookies  []cdpCookie
+	lock     sync.Mutex
+	commands map[string]json.RawMessage
+	closed   bool
+}
+
+func newFakeBrowser(html string, status int, cookies []cdpCookie) *fakeBrowser {
+	b := &fakeBrowser{
+		html:     html,
+		status:   status,
+		cookies:  cookies,
+		commands: make(map[string]json.RawMessage),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/json/new", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "PUT" {
+			http.Error(w, "PUT required", http.StatusMethodNotAllowed)
+			return
+		}
+		json.NewEncoder(w).Encode(cdpTarget{
+			ID:                   "tab",
+			WebSocketDebuggerURL: "ws://" + req.Host + "/devtools/page/tab",
+		})
+	})
+	mux.HandleFunc("/json/close/tab", func(w http.ResponseWriter, req *http.Request) {
+		b.lock.Lock()
+		b.closed = true
+		b.lock.Unlock()
+	})
+	mux.Handle("/devtools/page/tab", websocket.Handler(b.serveTab))
+	b.server = httptest.NewServer(mux)
+
+	return b
+}
+
+// serveTab answers the commands sent to a tab.
+func (b *fakeBrowser) serveTab(ws *websocket.Conn) {
+	for {
+		var cmd struct {
+			ID     int             `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := websocket.JSON.Receive(ws, &cmd); err != nil {
+			return
+		}
+
+		b.lock.Lock()
+		b.commands[cmd.Method] = cmd.Params
+		b.lock.Unlock()
+
+		var result interface{} = struct{}{}
+		switch cmd.Method {
+		case "Page.navigate":
+			result = map[string]string{"frameId": "frame"}
+			// The browser reports the document's response and the end of
+			// the loading while navigating.
+			websocket.Message.Send(ws, fmt.Sprintf(
+				`{"method":"Network.responseReceived","params":{"type":"Document","frameId":"frame","response":{"status":%d,"headers":{"Content-Type":"text/html; charset=iso-8859-1","X-Test":"yes"}}}}`,
+				b.status,
+			))
+			websocket.Message.Send(ws, `{"method":"Page.loadEventFired","params":{}}`)
+		case "Runtime.evaluate":
+			result = map[string]interface{}{"result": map[string]string{"value": b.html}}
+		case "Network.getCookies":
+			result = map[string]interface{}{"cookies": b.cookies}
+		}
+
+		websocket.JSON.Send(ws, map[string]interface{}{"id": cmd.ID, "result": result})
+	}
+}
+
+// command returns the parameters of the last command with a given method the
+// browser received, and whether it received one.
+func (b *fakeBrowser) command(method string) (json.RawMessage, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	params, ok := b.commands[method]
+	return params, ok
+}
+
+// fallbackFetcher is a Fetcher recording the URLs it is asked to fetch.
+type fallbackFetcher struct {
+	fetched []string
+}
+
+func (f *fallbackFetcher) Fetch(u *url.URL, userAgent string, headRequest bool) (*http.Response, error) {
+	f.fetched = append(f.fetched, u.String())
+	return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+}
+
+func TestChromeFetcherRendersPage(t *testing.T) {
+	html := "<html><head></head><body><p>Rendered</p></body></html>"
+	b := newFakeBrowser(html, http.StatusNotFound, nil)
+	defer b.server.Close()
+
+	f, err := NewChromeFetcher(&config.RendererConfig{Endpoint: b.server.URL, Timeout: 5}, nil, &fallbackFetcher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse("http://news.tld/article")
+	res, err := f.Fetch(u, "TestAgent", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, _ := ioutil.ReadAll(res.Body)
+	if string(body) != html {
+		t.Errorf("Body = %q, want %q", body, html)
+	}
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("Status code = %d, want %d", res.StatusCode, http.StatusNotFound)
+	}
+	if res.Header.Get("X-Test") != "yes" {
+		t.Errorf("Document's headers weren't kept: %v", res.Header)
+	}
+	// The serialised DOM is always encoded in UTF-8.
+	if ct := res.Header.Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	params, _ := b.command("Network.setUserAgentOverride")
+	if !strings.Contains(string(params), "TestAgent") {
+		t.Errorf("User agent wasn't overridden: %s", params)
+	}
+	params, _ = b.command("Page.navigate")
+	if !strings.Contains(string(params), u.String()) {
+		t.Errorf("Wrong page loaded: %s", params)
+	}
+	// Without a session, no header nor cookie is set.
+	if _, ok := b.command("Network.setExtraHTTPHeaders"); ok {
+		t.Error("Headers were set without a session")
+	}
+	if _, ok := b.command("Network.setCookies"); ok {
+		t.Error("Cookies were set without a session")
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.closed {
+		t.Error("Tab wasn't closed")
+	}
+}
+
+func TestChromeFetcherSendsSession(t *testing.T) {
+	b := newFakeBrowser("<html></html>", http.StatusOK, []cdpCookie{
+		{Name: "consent", Value: "yes", Domain: "news.tld", Path: "/", Expires: -1},
+	})
+	defer b.server.Close()
+
+	u, _ := url.Parse("http://news.tld/article")
+	jar, err := newCookieJar("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jar.SetCookies(u, []*http.Cookie{{Name: "sid", Value: "42"}})
+	session := &Session{headers: http.Header{"X-Api-Key": {"secret"}}, jar: jar}
+
+	f, err := NewChromeFetcher(&config.RendererConfig{Endpoint: b.server.URL, Timeout: 5}, session, &fallbackFetcher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := f.Fetch(u, "TestAgent", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	params, ok := b.command("Network.setExtraHTTPHeaders")
+	if !ok || !strings.Contains(string(params), `"X-Api-Key":"secret"`) {
+		t.Errorf("Session's headers weren't set: %s", params)
+	}
+	params, ok = b.command("Network.setCookies")
+	if !ok || !strings.Contains(string(params), `"name":"sid"`) || !strings.Contains(string(params), `"value":"42"`) {
+		t.Errorf("Session's cookies weren't set: %s", params)
+	}
+
+	// The cookies set while rendering the page are kept in the session.
+	if !session.hasCookie(u, "consent") {
+		t.Error("Cookie set by the website wasn't added to the session")
+	}
+}
+
+func TestChromeFetcherFallback(t *testing.T) {
+	fallback := &fallbackFetcher{}
+	f, err := NewChromeFetcher(&config.RendererConfig{Endpoint: "http://127.0.0.1:1"}, nil, fallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	robots, _ := url.Parse("http://news.tld/robots.txt")
+	page, _ := url.Parse("http://news.tld/article")
+	if _, err = f.Fetch(robots, "TestAgent", false); err != nil {
+		t.Error(err)
+	}
+	if _, err = f.Fetch(page, "TestAgent", true); err != nil {
+		t.Error(err)
+	}
+
+	if len(fallback.fetched) != 2 {
+		t.Errorf("robots.txt files and HEAD requests weren't delegated: %v", fallback.fetched)
+	}
+}
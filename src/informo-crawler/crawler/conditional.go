@@ -0,0 +1,137 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"common"
+
+	"github.com/PuerkitoBio/gocrawl"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/sirupsen/logrus"
+)
+
+// setValidators makes a request conditional, using the validators the website
+// sent along with the requested page the last time it was visited, if any. If
+// the validators couldn't be retrieved, the request is sent unconditionally,
+// since that only means the page is downloaded in full.
+func (s *Session) setValidators(req *http.Request) {
+	if s.db == nil {
+		return
+	}
+
+	validators, err := s.db.RetrievePageValidators(req.URL.String())
+	if err != nil || validators == nil {
+		return
+	}
+
+	if len(validators.ETag) > 0 {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if len(validators.LastModified) > 0 {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+}
+
+// followKnownLinks handles a page that didn't change since it was last visited:
+// instead of parsing it again, the links found in it during the last visit are
+// handed to the scheduler, so the pages they lead to are still crawled. It must
+// be called before the scheduler is told gocrawl is done with the page, so the
+// links are handed to gocrawl before the crawl can end.
+func (e *Extender) followKnownLinks(ctx *gocrawl.URLContext) {
+	u := ctx.URL().String()
+	e.stats.addNotModified(u)
+
+	validators, err := e.db.RetrievePageValidators(u)
+	if err != nil {
+		e.errChan <- &loggedError{
+			fmt.Errorf("Couldn't retrieve the links of an unchanged page: %v", err),
+			logrus.Fields{"url": u},
+		}
+		return
+	}
+
+	e.log.WithField("url", u).Debug("Page didn't change since the last visit")
+
+	if validators != nil {
+		e.followLinks(ctx, validators.Links, nil)
+	}
+}
+
+// savePageValidators saves the validators the website sent along with a page
+// that isn't expected to be an article (see isArticlePage), if any, along with
+// the links found in it, so the page can be requested conditionally during the
+// next runs, and so the links that appeared on it since can be told apart.
+// Articles aren't concerned since they aren't visited again once they're saved,
+// and neither are the pages expected to be articles from which no article could
+// be extracted, since they need to be parsed again during the next runs.
+// Returns the links found in the page during its previous visit, or nil if it
+// wasn't visited before.
+// Returns an error if the previous links couldn't be retrieved, or if the
+// validators couldn't be saved.
+func (e *Extender) savePageValidators(
+	u *url.URL, res *http.Response, links []string,
+) (known map[string]bool, err error) {
+	previous, err := e.db.RetrievePageValidators(u.String())
+	if err != nil {
+		return
+	}
+	if previous != nil {
+		known = make(map[string]bool, len(previous.Links))
+		for _, link := range previous.Links {
+			known[link] = true
+		}
+	}
+
+	err = e.db.SavePageValidators(e.website.Identifier, &common.PageValidators{
+		URL:          u.String(),
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+		Links:        links,
+	})
+
+	return
+}
+
+// pageLinks returns the absolute URLs of the HTTP(S) links found in a page,
+// without their fragment, and without duplicates.
+func pageLinks(u *url.URL, doc *goquery.Document) []string {
+	links := make([]string, 0)
+	if doc == nil {
+		return links
+	}
+	seen := make(map[string]bool)
+
+	doc.Find("a[href]").Each(func(_ int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		link, err := u.Parse(strings.TrimSpace(href))
+		if err != nil || (link.Scheme != "http" && link.Scheme != "https") {
+			return
+		}
+		link.Fragment = ""
+
+		if l := link.String(); !seen[l] {
+			seen[l] = true
+			links = append(links, l)
+		}
+	})
+
+	return links
+}
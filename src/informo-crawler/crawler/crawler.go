@@ -16,9 +16,12 @@
 package crawler
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"time"
 
+	"common"
 	"common/config"
 	"common/database"
 
@@ -27,31 +30,64 @@ import (
 )
 
 // Crawler represents a website crawler, with its logger (a logrus logger with
-// the "website" field prefilled), a gocrawl.Crawler instance and data on the
-// website to crawl, along with the channels the extender will use to raise
-// errors and request the crawl to be terminated.
+// the "website" and "run_id" fields prefilled), a gocrawl.Crawler instance and
+// data on the website to crawl, along with the channels the extender will use to
+// raise errors and request the crawl to be terminated, and the one gocrawl's
+// crawler reports its termination on. It also keeps the extender, the run's
+// identifier and the error that made the crawl fail (if any), to report on the
+// run once it's over.
 type Crawler struct {
-	Log     *logrus.Entry
-	c       *gocrawl.Crawler
-	website *config.Website
-	errChan chan error
-	endChan chan string
+	Log      *logrus.Entry
+	c        *gocrawl.Crawler
+	ext      *Extender
+	website  *config.Website
+	seeds    []string
+	session  *Session
+	agent    string
+	runID    string
+	err      error
+	errChan  chan error
+	endChan  chan string
+	doneChan chan error
 }
 
 // NewCrawler takes configuration and database parameters, creates the channels
 // the extender will use to raise errors and request the crawl to be terminated,
-// uses all that data to instantiate an Extender. It then uses it and some
-// configuration parameters to instantiate a Crawler.
-// Returns an error if there was an issue instantiating the extender.
+// instantiates the session and the fetcher required by the website's
+// configuration, and uses all that data to instantiate an Extender. It then uses
+// it and some configuration parameters to instantiate a Crawler.
+// Returns an error if there was an issue instantiating the session, the fetcher
+// or the extender.
 func NewCrawler(
 	cfg config.CrawlerConfig, db *database.Database, website *config.Website,
 ) (*Crawler, error) {
 	errChan := make(chan error)
 	endChan := make(chan string)
 
-	log := logrus.WithField("website", website.Identifier)
-	// Instantiate the extender and the options.
-	ext, err := NewExtender(db, website, log, errChan, endChan)
+	// Identify the run in all of its logs.
+	runID := newRunID()
+	fields := logrus.Fields{
+		"website": website.Identifier,
+		"run_id":  runID,
+	}
+	log := common.Logger("crawler").WithFields(fields)
+	// Instantiate the session, the fetcher, the extender and the options.
+	// Recorded responses are replayed without sending any request, so they
+	// don't need a session.
+	var session *Session
+	var err error
+	if len(cfg.ReplayDir) == 0 {
+		if session, err = NewSession(cfg, website, db); err != nil {
+			return nil, err
+		}
+	}
+	fetcher, err := NewFetcher(cfg, website, session)
+	if err != nil {
+		return nil, err
+	}
+	ext, err := NewExtender(
+		db, cfg, website, fetcher, common.Logger("extender").WithFields(fields), errChan, endChan,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -61,23 +97,64 @@ func NewCrawler(
 	opts.RobotUserAgent = cfg.RobotAgent
 	opts.UserAgent = cfg.UserAgent
 	opts.CrawlDelay = cfg.CrawlDelay * time.Second
+	// There's no need to be polite with recorded responses.
+	if len(cfg.ReplayDir) > 0 {
+		opts.CrawlDelay = 0
+	}
 	opts.MaxVisits = website.MaxVisits
+
+	// Crawl the website's archive pages instead of its start point in backfill
+	// mode, which has its own visit budget.
+	seeds := []string{website.StartPoint}
+	if cfg.Backfill != nil {
+		seeds = archivePages(website.Backfill, cfg.Backfill)
+		opts.MaxVisits = website.Backfill.MaxVisits
+		log.Infof("Backfilling %d archive pages", len(seeds))
+	}
+	opts.URLNormalizationFlags = urlNormalizationFlags
 	opts.LogFlags = gocrawl.LogInfo
 
 	return &Crawler{
-		Log:     log,
-		c:       gocrawl.NewCrawlerWithOptions(opts),
-		website: website,
-		errChan: errChan,
-		endChan: endChan,
+		Log:      log,
+		c:        gocrawl.NewCrawlerWithOptions(opts),
+		ext:      ext,
+		website:  website,
+		seeds:    seeds,
+		session:  session,
+		agent:    cfg.UserAgent,
+		runID:    runID,
+		errChan:  errChan,
+		endChan:  endChan,
+		doneChan: make(chan error),
 	}, nil
 }
 
-// Run tells a crawler to start crawling, and watches the channels its extender
-// will use to report error and to request the crawl to be aborted.
-// When the crawl aborted, returns with the reason of its abortion.
+// Run logs in to the website if required, tells a crawler to start crawling,
+// and watches the channels its extender will use to report error and to request
+// the crawl to be aborted. If the extender requests the crawl to be aborted, the
+// crawler is stopped and the crawl is considered as failed, and so is it if
+// logging in failed. The session's cookies are saved once the crawl is over.
+// Returns once the crawl is over, with the reason that made it stop if it
+// didn't stop because it visited the whole website.
 func (c *Crawler) Run() (stopReason string) {
 	var err error
+	var aborted bool
+
+	// Save the session's cookies once the crawl is over, so they can be reused
+	// during the next run.
+	defer func() {
+		if saveErr := c.session.Save(); saveErr != nil {
+			c.Log.Error(fmt.Errorf("Couldn't save cookies: %v", saveErr))
+		}
+	}()
+
+	// Log in or accept the website's consent form before crawling it, if
+	// required.
+	if err = c.session.Login(c.agent); err != nil {
+		c.err = fmt.Errorf("Login failed: %v", err)
+		c.Log.Error(c.err)
+		return c.err.Error()
+	}
 
 	// We run the crawler in a goroutine. Since we also call this function in a
 	// goroutine, and gocrawl's crawler starts another goroutine, it makes 3
@@ -90,30 +167,64 @@ func (c *Crawler) Run() (stopReason string) {
 	for {
 		select {
 		case err = <-c.errChan:
-			c.Log.Error(err)
-		case stopReason = <-c.endChan:
-			return stopReason
+			// Errors reported by the extender carry their own fields.
+			if logged, ok := err.(*loggedError); ok {
+				c.Log.WithFields(logged.fields).Error(logged.err)
+			} else {
+				c.Log.Error(err)
+			}
+		case reason := <-c.endChan:
+			// Only the first abortion request is taken into account, the
+			// crawler might take some time to stop.
+			if !aborted {
+				aborted = true
+				stopReason = reason
+				c.err = fmt.Errorf("Crawl aborted: %s", reason)
+				c.Log.Warn(c.err)
+				c.c.Stop()
+			}
+		case err = <-c.doneChan:
+			// gocrawl.ErrInterrupted is expected when the crawl was aborted,
+			// and stopping after reaching the crawl limit is a normal and
+			// expected behaviour.
+			if aborted {
+				return
+			}
+			if err == gocrawl.ErrMaxVisits {
+				return err.Error()
+			}
+			if err != nil {
+				c.err = fmt.Errorf("Crawling failed: %v", err)
+				c.Log.Error(c.err)
+				return c.err.Error()
+			}
+			return
 		}
 	}
 }
 
-// launchCrawler runs the gocrawl's crawler instance. If the crawler terminates
-// with an error, raises it except if it's gocrawl.ErrMaxVisits (because stopping
-// after reaching the crawl limit is a normal and expected behaviour). In both
-// cases it calls for abortion with the error's message as the reason.
-// If there's no error (which means the crawler just finished working), requests
-// an abortion without giving any reason.
-func (c *Crawler) launchCrawler() {
-	var err error
+// newRunID generates a random identifier for a run of a crawler.
+func newRunID() string {
+	var id [8]byte
+	// crypto/rand.Read only fails if the system's random number generator is
+	// broken, in which case there's not much we can do.
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
 
-	if err = c.c.Run(c.website.StartPoint); err != nil && err != gocrawl.ErrMaxVisits {
-		err = fmt.Errorf("Crawling failed: %v", err)
-		c.errChan <- err
-	}
+// loggedError represents an error that should be logged with the given fields.
+type loggedError struct {
+	err    error
+	fields logrus.Fields
+}
 
-	if err != nil {
-		c.endChan <- err.Error()
-	}
+// Error implements error.Error
+func (e *loggedError) Error() string {
+	return e.err.Error()
+}
 
-	c.endChan <- ""
+// launchCrawler runs the gocrawl's crawler instance, and reports the error it
+// terminated with, if any, once it's done.
+func (c *Crawler) launchCrawler() {
+	c.doneChan <- c.c.Run(c.seeds)
 }
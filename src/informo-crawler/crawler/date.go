@@ -0,0 +1,115 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"common/config"
+)
+
+// isoDateLayouts are the layouts of the ISO 8601 and RFC 3339 dates (e.g. in the
+// datetime attribute of <time> nodes), which are tried after a website's date
+// formats.
+var isoDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// websiteLocation returns the time zone a website's dates are written in when
+// they don't specify one.
+func websiteLocation(website *config.Website) *time.Location {
+	if website.Location == nil {
+		return time.UTC
+	}
+
+	return website.Location
+}
+
+// parseDate parses a date using each of a website's date formats in order, and
+// then each of the ISO 8601 layouts, until one of them works. Dates which don't
+// specify their time zone are considered to be in the website's one.
+// Returns an error if none of them did.
+func parseDate(website *config.Website, raw string) (time.Time, error) {
+	loc := websiteLocation(website)
+	for _, layout := range website.DateFormats {
+		if date, err := time.ParseInLocation(layout, raw, loc); err == nil {
+			return date, nil
+		}
+	}
+	for _, layout := range isoDateLayouts {
+		if date, err := time.ParseInLocation(layout, raw, loc); err == nil {
+			return date, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("Couldn't parse date %q with any of the date formats", raw)
+}
+
+// dateFromURL looks for an article's date in its URL, using the website's date
+// URL pattern. If the pattern doesn't capture the day, the first day of the
+// month is used. The date starts at midnight in the website's time zone.
+// Returns false if the website doesn't have one, or if the URL doesn't contain
+// a valid date.
+func dateFromURL(website *config.Website, u *url.URL) (time.Time, bool) {
+	if website.DateURLRegexp == nil || u == nil {
+		return time.Time{}, false
+	}
+
+	m := website.DateURLRegexp.FindStringSubmatch(u.String())
+	if m == nil {
+		return time.Time{}, false
+	}
+
+	year, month, day := 0, 0, 1
+	for i, name := range website.DateURLRegexp.SubexpNames() {
+		var n *int
+		switch name {
+		case "year":
+			n = &year
+		case "month":
+			n = &month
+		case "day":
+			n = &day
+		}
+		// The day's group can be optional.
+		if n == nil || (name == "day" && len(m[i]) == 0) {
+			continue
+		}
+
+		var err error
+		if *n, err = strconv.Atoi(m[i]); err != nil {
+			return time.Time{}, false
+		}
+	}
+
+	// A date such as February 31st would be normalised to another date.
+	date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, websiteLocation(website))
+	if date.Year() != year || int(date.Month()) != month || date.Day() != day {
+		return time.Time{}, false
+	}
+
+	return date, true
+}
@@ -17,80 +17,189 @@ package crawler
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
+	"sync"
 
+	"common"
 	"common/config"
 	"common/database"
 
 	"github.com/PuerkitoBio/gocrawl"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/net/html"
 )
 
 // Extender implements gocrawl.Extender.
-// Other fields also include the database, a logrus logger (with the "website"
-// field prefilled), and channels for reporting errors to the parent goroutine
-// or abort the process.
+// Other fields also include the database, the fetcher used to retrieve pages, a
+// logrus logger (with the "website" and "run_id" fields prefilled), the articles
+// already saved, the extraction statistics of the current run, the scheduler
+// deciding which links are visited first, and channels for reporting errors to
+// the parent goroutine or abort the process.
 type Extender struct {
 	gocrawl.DefaultExtender
 	db              *database.Database
 	website         *config.Website
+	fetcher         Fetcher
 	log             *logrus.Entry
-	visitedArticles map[string]bool
+	visitedArticles visitedSet
+	recentlySeen    map[string]bool
+	incremental     *incrementalCrawl
+	stats           *runStats
+	scheduler       *scheduler
+	abortOnce       sync.Once
 	errChan         chan error
 	abortChan       chan string
 }
 
-// NewExtender instantiate an Extender.
+// NewExtender instantiate an Extender, which keeps track of the articles already
+// saved using the visited set configured for the crawler (see newVisitedSet),
+// and only looks for new articles if the crawler is in incremental mode. In
+// backfill mode, links are followed from the website's archive pages up to the
+// backfill's maximum depth instead of the website's.
 // Returns an error if an issue happened while loading the visited article's URLs
 // from the database.
 func NewExtender(
-	db *database.Database, website *config.Website, log *logrus.Entry,
-	errCh chan error, abortCh chan string,
+	db *database.Database, cfg config.CrawlerConfig, website *config.Website,
+	fetcher Fetcher, log *logrus.Entry, errCh chan error, abortCh chan string,
 ) (*Extender, error) {
-	// Load the URLs of visited articles from the database so we can use it to
-	// filter the enqueuing process and speed the crawls up.
-	visited, err := db.RetrieveArticleURLsForWebsite(website.Identifier)
+	// Load the visited articles so we can use them to filter the enqueuing
+	// process and speed the crawls up.
+	visited, err := newVisitedSet(cfg.VisitedSet, db, website.Identifier, log)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Infof("Loaded %d visited URLs for this website", len(visited))
+	// Load the fingerprints of the recent articles from all websites, so the
+	// articles saved during this run can be matched with their near-duplicates.
+	if err = duplicates.load(db); err != nil {
+		return nil, err
+	}
+
+	maxDepth := website.MaxDepth
+	if cfg.Backfill != nil {
+		maxDepth = backfillMaxDepth(website.Backfill)
+	}
 
 	// Instantiate the extender.
-	return &Extender{
+	e := &Extender{
 		DefaultExtender: gocrawl.DefaultExtender{},
 		db:              db,
 		website:         website,
+		fetcher:         fetcher,
 		log:             log,
 		visitedArticles: visited,
+		stats:           newRunStats(website.Identifier),
+		scheduler:       newScheduler(maxDepth, articlePatterns(website)),
 		errChan:         errCh,
 		abortChan:       abortCh,
-	}, nil
+	}
+
+	if cfg.Incremental {
+		e.incremental = newIncrementalCrawl(cfg.MaxKnownArticles)
+	}
+
+	// Load the pages that don't need to be visited again yet.
+	if err = e.loadRecentlySeen(); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// Start implements gocrawl.Extender.Start
+// Records the time the run started at, and hands the seeds to the scheduler if
+// they're URLs, so the links found from them are scheduled by it.
+func (e *Extender) Start(seeds interface{}) interface{} {
+	e.stats.start()
+
+	switch seeds := seeds.(type) {
+	case string:
+		return e.scheduler.seed([]string{seeds})
+	case []string:
+		return e.scheduler.seed(seeds)
+	}
+
+	return seeds
+}
+
+// Fetch implements gocrawl.Extender.Fetch
+// Delegates the retrieval of the page to the extender's fetcher, and updates the
+// metrics with the response. If the page didn't change since it was last
+// visited, the links it contained are followed again (see followKnownLinks). If
+// the page won't be visited, the scheduler is told gocrawl is done with it. If
+// the fetcher gave up on the website's host after too many failed requests,
+// requests the crawl to be aborted.
+func (e *Extender) Fetch(ctx *gocrawl.URLContext, userAgent string, headRequest bool) (*http.Response, error) {
+	pagesFetchedMetric.Inc(e.website.Identifier)
+	e.stats.addFetch()
+
+	res, err := e.fetcher.Fetch(ctx.URL(), userAgent, headRequest)
+	if res != nil {
+		observeResponse(e.website.Identifier, res)
+		if res.StatusCode == http.StatusNotModified {
+			e.followKnownLinks(ctx)
+		}
+	}
+
+	// gocrawl only visits pages with a 2xx status code, and doesn't call any
+	// other method for the ones it doesn't visit.
+	if !headRequest && (err != nil || res.StatusCode < 200 || res.StatusCode >= 300) {
+		e.saveFetchError(ctx, res)
+		e.release(ctx)
+	}
+
+	if err != nil && isBreakerError(err) {
+		e.abortOnce.Do(func() {
+			e.abort(err.Error())
+		})
+	}
+
+	return res, err
 }
 
 // Filter implements gocrawl.Extender.Filter
 // Tells the crawler if an URL should be enqueued for visiting, according to
 // whether it has already been visited in the current crawl, or whether it matches
-// the URL of an article that has already been saved in the database.
+// the URL of an article that has already been saved in the database (see
+// accept), or whether it doesn't need to be visited again yet (see
+// isRecentlySeen), which also applies to the targets of redirections. The
+// website's start points, including the archive pages of a backfill, are always
+// visited. If it shouldn't, the scheduler is told gocrawl is done with it.
 func (e *Extender) Filter(ctx *gocrawl.URLContext, isVisited bool) bool {
-	// Remove the fragment (#foobar) part of the URL.
 	// Because the context is a reference here, and because the same reference
-	// is passed along all functions, removing the fragment here will ensure it
-	// will be removed at every other point in the work flow (i.e. we won't save
-	// an article's URL with a fragment part in the database).
-	ctx.URL().Fragment = ""
+	// is passed along all functions, normalizing the URL here will ensure it
+	// will be normalized at every other point in the work flow (i.e. we won't
+	// save an article's URL with a fragment part in the database).
+	e.normalizeURL(ctx.URL())
+
+	if accepted, _ := e.accept(ctx.URL(), isVisited); !accepted {
+		e.release(ctx)
+		return false
+	}
+
+	if e.isRecentlySeen(ctx.URL(), ctx.State) {
+		e.stats.addSkipped(ctx.URL().String(), skipRecentlySeen)
+		e.release(ctx)
+		return false
+	}
+
+	return true
+}
+
+// normalizeURL removes the fragment (#foobar) part of a URL, along with the
+// keys of its query string the website's configuration requires to ignore.
+func (e *Extender) normalizeURL(u *url.URL) {
+	u.Fragment = ""
 
 	if e.website.Query != nil {
 		// If required by the configuration, iterate over the keys from the query
 		// (?foo=bar) part of the URL to only keep the ones set as exceptions,
 		// or remove them, accordingly with the IgnoreAll value.
 		if len(e.website.Query.Except) > 0 {
-			q := ctx.URL().Query()
+			q := u.Query()
 			// Iterate over the query keys.
 			for k := range q {
 				// If IgnoreAll is set to true, the default behaviour is to delete
@@ -111,21 +220,26 @@ func (e *Extender) Filter(ctx *gocrawl.URLContext, isVisited bool) bool {
 				}
 			}
 
-			// Apply the updated query string to the URL. For the same reason as
-			// the one already explained above, changing the query string here will
-			// also change it for all of the following steps of the crawling process.
-			ctx.URL().RawQuery = q.Encode()
+			// Apply the updated query string to the URL.
+			u.RawQuery = q.Encode()
 		} else if e.website.Query.IgnoreAll {
 			// If no exception is set, remove all the query string from the URL,
 			// but only if IgnoreAll is set to true.
-			ctx.URL().RawQuery = ""
+			u.RawQuery = ""
 		}
 	}
+}
 
+// accept checks whether a URL should be visited, i.e. it hasn't already been
+// visited in the current crawl, it doesn't match the URL of an article that has
+// already been saved in the database, and it passes the website's filters. The
+// URLs that don't are counted in the run's statistics. Also returns whether the
+// URL matches the URL of a saved article.
+func (e *Extender) accept(u *url.URL, isVisited bool) (accepted bool, known bool) {
 	// Check if the fragmentless (and possibly queryless) URL matches the URL of
-	// an article that has already been saved in the database. Only check if the
-	// URL is in the map, we don't actually care about the value attached.
-	_, inMap := e.visitedArticles[ctx.URL().String()]
+	// an article that has already been saved in the database, or one of its
+	// variants.
+	inMap := e.visitedArticles.has(u.String())
 
 	// Check if the URL matches with the exclude and restrict filters. To be
 	// accepted, a URL must pass the restrict filter and not pass the exclude one.
@@ -133,24 +247,38 @@ func (e *Extender) Filter(ctx *gocrawl.URLContext, isVisited bool) bool {
 	var matchRestrict, matchExclude = true, false
 	if e.website.Filters != nil {
 		if e.website.Filters.Restrict != nil {
-			matchRestrict = e.website.Filters.Restrict.MatchString(ctx.URL().String())
+			matchRestrict = e.website.Filters.Restrict.MatchString(u.String())
 		}
 		if e.website.Filters.Exclude != nil {
-			matchExclude = e.website.Filters.Exclude.MatchString(ctx.URL().String())
+			matchExclude = e.website.Filters.Exclude.MatchString(u.String())
 		}
 	}
 
-	return !isVisited && !inMap && (matchRestrict && !matchExclude)
+	// Count the URLs that are skipped only because of this extender.
+	if !isVisited && inMap {
+		e.stats.addSkipped(u.String(), skipKnown)
+	} else if !isVisited && !(matchRestrict && !matchExclude) {
+		e.stats.addSkipped(u.String(), skipFiltered)
+	}
+
+	return !isVisited && !inMap && (matchRestrict && !matchExclude), inMap
 }
 
 // Visit implements gocrawl.Extender.Visit
 // Parses a web page to check if it contains a news item, and if so extract all
-// data available and save it in the database. Also manipulates the content to
-// replace all relative links to absolute ones, and to remove <aside> and <script>
-// nodes.
+// data available and save it in the database (see Extract), under its canonical
+// URL (see CanonicalURL), unless it was already saved. Pages which URL
+// doesn't match the website's article patterns, or matches its listing
+// patterns, aren't parsed (see isArticlePage). The validators of the pages that
+// aren't parsed are saved so they can be requested conditionally during the
+// next runs (see savePageValidators), but not the ones of the pages which were
+// parsed without finding an article. In both cases, the links found in the page
+// are handed to the scheduler instead of letting gocrawl enqueue them (see
+// followLinks).
 // Raises an error (to the parent goroutine) if there was an issue processing the
 // item's content (either replacing relative links to absolute ones, or retrieving
-// its HTML), parsing the item's date, or saving the item in the database.
+// its HTML), parsing the item's date, or saving the item or the page's
+// validators in the database.
 func (e *Extender) Visit(ctx *gocrawl.URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
 	// Initialise the error that will be raised to the parent goroutine in case
 	// it is needed.
@@ -160,155 +288,256 @@ func (e *Extender) Visit(ctx *gocrawl.URLContext, res *http.Response, doc *goque
 	}
 
 	var err error
-	var description, author *string
-	var contentNodes, titleNodes, dateNodes *goquery.Selection
-	var nodes []*html.Node
-
-	// Find content, title and date using the CSS selectors specified in the
-	// configuration file.
-	contentNodes = doc.Find(e.website.Selectors.Content)
-	titleNodes = doc.Find(e.website.Selectors.Title)
-	dateNodes = doc.Find(e.website.Selectors.Date)
-
-	// There should only be one match for content and title. In some weird configurations,
-	// there can be more than one match for the date. This is fine as long as there's at
-	// least one, only the first match will be used.
-	// If one of theses requirements isn't met, it means the page isn't an article.
-	if len(contentNodes.Nodes) != 1 || len(titleNodes.Nodes) != 1 || len(dateNodes.Nodes) == 0 {
-		e.log.WithFields(logrus.Fields{
-			"content_matches": len(contentNodes.Nodes),
-			"title_matches":   len(titleNodes.Nodes),
-			"date_matches":    len(dateNodes.Nodes),
-			"page_url":        ctx.URL().String(),
-		}).Debug("Current page isn't an article")
+	var warcRecordID *string
+
+	// If the website's responses are archived, retrieve the ID of the WARC
+	// record containing the page's response. This needs to be done before
+	// checking if the page is an article, so the ID isn't kept in memory for
+	// pages that aren't.
+	if archiver, ok := e.fetcher.(*WARCFetcher); ok {
+		if id := archiver.ResponseRecordID(ctx.URL().String()); len(id) > 0 {
+			warcRecordID = &id
+		}
+	}
 
-		return nil, true
+	// Only the pages expected to be articles are taken into account when
+	// computing the selectors' match rates, since the selectors aren't
+	// supposed to match in the other ones.
+	extraction := &Extraction{}
+	articlePage := e.isArticlePage(ctx.URL())
+	if articlePage {
+		extraction = Extract(e.website, doc, ctx.URL())
 	}
+	e.stats.addVisit(extraction, articlePage && e.scheduler.isArticleLink(ctx.URL()))
+	links := pageLinks(ctx.URL(), doc)
 
-	// Look for optional data, starting with the description.
-	if len(e.website.Selectors.Description) > 0 {
-		nodes = doc.Find(e.website.Selectors.Description).Nodes
-		if len(nodes) > 0 {
-			description = new(string)
-			*description = strings.Trim(nodes[0].FirstChild.Data, " \t\n")
-		}
+	// Report the errors that happened during the extraction.
+	for _, err = range extraction.Errors {
+		crawlError.Err = err
+		e.Error(crawlError)
 	}
-	// Search for the post's author.
-	if len(e.website.Selectors.Author) > 0 {
-		nodes = doc.Find(e.website.Selectors.Author).Nodes
-		if len(nodes) > 0 {
-			author = new(string)
-			if nodes[0].FirstChild.Data == "a" {
-				// Sometimes there's a link on the author's name, so we need to
-				// go deeper into the children to find the text data.
-				*author = strings.Trim(nodes[0].FirstChild.FirstChild.Data, " \t\n")
-			} else {
-				*author = strings.Trim(nodes[0].FirstChild.Data, " \t\n")
-			}
 
+	if extraction.Article == nil {
+		e.log.WithFields(logrus.Fields{
+			"content_matches": extraction.Matches["content"],
+			"title_matches":   extraction.Matches["title"],
+			"date_matches":    extraction.Matches["date"],
+			"url":             ctx.URL().String(),
+		}).Debug("Current page isn't an article")
+
+		// Only listing pages are requested conditionally: if the extraction
+		// failed on a page expected to be an article, it must be parsed again
+		// during the next runs, e.g. once the website's selectors are fixed,
+		// rather than being reported as unchanged.
+		var known map[string]bool
+		if !articlePage {
+			if known, err = e.savePageValidators(ctx.URL(), res, links); err != nil {
+				crawlError.Err = err
+				e.Error(crawlError)
+			}
 		}
+		e.saveSeenURL(ctx.URL(), res.StatusCode, links)
+		e.followLinks(ctx, links, known)
+
+		return nil, false
 	}
-	// Search for the thumbnail. If one is found, add it at the very beginning of
-	// the content.
-	if len(e.website.Selectors.Thumbnail) > 0 {
-		nodes = doc.Find(e.website.Selectors.Thumbnail).Nodes
-		if len(nodes) > 0 && nodes[0].Data == "img" {
-			contentNodes.PrependNodes(nodes[0])
-		}
+
+	article := extraction.Article
+	article.WARCRecordID = warcRecordID
+
+	// The article can have been saved under another URL, e.g. if the page was
+	// reached through its AMP version, or through a URL which differs from its
+	// canonical URL.
+	if e.visitedArticles.has(article.URL) {
+		e.log.WithFields(logrus.Fields{
+			"url":           ctx.URL().String(),
+			"canonical_url": article.URL,
+		}).Debug("Article already saved under its canonical URL")
+		e.followLinks(ctx, links, nil)
+
+		return nil, false
+	}
+
+	var lang string
+	if article.Language != nil {
+		lang = *article.Language
 	}
 
-	// Remove useless content and scripts.
-	contentNodes.Find("aside").Remove()
-	contentNodes.Find("script").Remove()
+	e.log.WithFields(logrus.Fields{
+		"url":      article.URL,
+		"title":    article.Title,
+		"date":     article.Date.String(),
+		"language": lang,
+	}).Info("Saving article")
 
-	// Make relative links absolute.
-	contentNodes.Find("a").Map(func(i int, selection *goquery.Selection) (s string) {
-		if err = urlRelativeToAbsolute(selection, doc, "href"); err != nil {
+	// Saving the item in the database.
+	if err = e.db.SaveArticle(e.website.Identifier, article); err != nil {
+		crawlError.Err = err
+		e.Error(crawlError)
+	} else {
+		e.visitedArticles.add(article.URL)
+		e.stats.addArticle()
+		articlesSavedMetric.Inc(e.website.Identifier)
+
+		if err = e.saveDuplicate(article); err != nil {
 			crawlError.Err = err
 			e.Error(crawlError)
 		}
+	}
+	e.followLinks(ctx, links, nil)
 
-		return
-	})
-	contentNodes.Find("img").Map(func(i int, selection *goquery.Selection) (s string) {
-		if err = urlRelativeToAbsolute(selection, doc, "src"); err != nil {
-			crawlError.Err = err
-			e.Error(crawlError)
+	return nil, false
+}
+
+// saveDuplicate looks for a near-duplicate of a saved article among the recent
+// articles from all websites, and saves it in the database if one is found.
+// Returns an error if the duplicate couldn't be saved.
+func (e *Extender) saveDuplicate(article *common.Article) error {
+	if article.Fingerprint == nil {
+		return nil
+	}
+
+	original, distance := duplicates.match(article.URL, *article.Fingerprint)
+	if len(original) == 0 {
+		return nil
+	}
+
+	e.log.WithFields(logrus.Fields{
+		"url":      article.URL,
+		"original": original,
+		"distance": distance,
+	}).Info("Article is a near-duplicate of an already saved article")
+
+	return e.db.SaveDuplicate(article.URL, original, distance)
+}
+
+// Visited implements gocrawl.Extender.Visited
+// Tells the scheduler gocrawl is done with the page.
+func (e *Extender) Visited(ctx *gocrawl.URLContext, harvested interface{}) {
+	e.release(ctx)
+}
+
+// End implements gocrawl.Extender.End
+// Closes the extender's fetcher if it needs to be closed once the crawl has
+// ended, closes the set of visited articles, which saves it if it needs to be
+// saved, and saves the run's extraction statistics (see saveRunStats).
+func (e *Extender) End(err error) {
+	if closer, ok := e.fetcher.(io.Closer); ok {
+		if closeErr := closer.Close(); closeErr != nil {
+			e.errChan <- fmt.Errorf("Couldn't close fetcher: %v", closeErr)
 		}
+	}
 
-		return
-	})
+	if visitedErr := e.visitedArticles.close(); visitedErr != nil {
+		e.errChan <- fmt.Errorf("Couldn't save visited URLs: %v", visitedErr)
+	}
 
-	// Extract the HTML content.
-	content, err := contentNodes.Html()
-	if err != nil {
-		crawlError.Err = err
-		e.Error(crawlError)
+	if statsErr := e.saveRunStats(); statsErr != nil {
+		e.errChan <- fmt.Errorf("Couldn't save run statistics: %v", statsErr)
 	}
+}
+
+// saveRunStats compares the selectors' match rates of the run that just ended
+// with the previous runs on the website, flagging the website as degraded if
+// they dropped sharply (see degradedSelectors), logs the run's extraction
+// statistics, and saves them in the database.
+// Returns an error if the previous runs couldn't be retrieved, or if the run
+// couldn't be saved.
+func (e *Extender) saveRunStats() error {
+	run := e.stats.end()
 
-	// Trim unnecessary space, tabs and line breaks.
-	title := strings.Trim(titleNodes.Nodes[0].FirstChild.Data, " \t\n")
-	date := strings.Trim(dateNodes.Nodes[0].FirstChild.Data, " \t\n")
-	// Convert the date into a time.Time instance so it can be stored with a DATE
-	// type into PostgreSQL.
-	dateTime, err := time.Parse(e.website.DateFormat, date)
+	history, err := e.db.RetrieveNLatestCrawlRunsForWebsite(e.website.Identifier, healthHistorySize)
 	if err != nil {
-		crawlError.Err = err
-		e.Error(crawlError)
+		return err
 	}
 
-	e.log.WithFields(logrus.Fields{
-		"title": title,
-		"date":  dateTime.String(),
-	}).Info("Saving article")
+	degraded := degradedSelectors(&run, history)
+	run.Degraded = len(degraded) > 0
+	e.stats.setDegraded(run.Degraded)
 
-	// Saving the item in the database.
-	if err = e.db.SaveArticle(
-		e.website.Identifier, ctx.URL(), title,
-		description, content, author, dateTime,
-	); err != nil {
-		crawlError.Err = err
-		e.Error(crawlError)
+	fields := logrus.Fields{
+		"pages_visited":         run.PagesVisited,
+		"article_pages_visited": run.ArticlePagesVisited,
+		"articles_saved":        run.ArticlesSaved,
+		"date_parse_failures":   run.DateParseFailures,
+	}
+	for name, rate := range run.MatchRates() {
+		fields[name+"_match_rate"] = rate
 	}
 
-	return nil, true
+	if run.Degraded {
+		fields["degraded_selectors"] = strings.Join(degraded, ",")
+		e.log.WithFields(fields).Warn(
+			"Selectors' match rates dropped sharply, the website's selectors might be broken",
+		)
+	} else {
+		e.log.WithFields(fields).Info("Run ended")
+	}
+
+	return e.db.SaveCrawlRun(&run)
 }
 
 // Error implements gocrawl.Extender.Error
 // Takes a *gocrawl.CrawlError and send the according error message to the parent
-// goroutine, according to the data provided.
+// goroutine, according to the data provided, along with the error's kind and
+// URL so they can be logged as fields. Also counts the error in the metrics, by
+// kind. Pages that didn't change since they were last visited are reported by
+// gocrawl as having a non-2xx status code, which isn't considered as an error.
 func (e *Extender) Error(err *gocrawl.CrawlError) {
+	if err != nil && err.Kind == gocrawl.CekHttpStatusCode && err.Ctx != nil &&
+		e.stats.isNotModified(err.Ctx.URL().String()) {
+		return
+	}
+
 	if err != nil {
+		errorsMetric.Inc(e.website.Identifier, err.Kind.String())
+		if err.Kind == gocrawl.CekFetch || err.Kind == gocrawl.CekHttpStatusCode {
+			e.stats.addHTTPError()
+		}
+
+		fields := logrus.Fields{"error_kind": err.Kind.String()}
+		if err.Ctx != nil {
+			fields["url"] = err.Ctx.URL().String()
+		}
+
 		if err.Ctx == nil {
 			if err.Err == nil {
-				e.errChan <- fmt.Errorf(
+				e.errChan <- &loggedError{fmt.Errorf(
 					"Unknown %s error",
 					err.Kind.String(),
-				)
+				), fields}
 			} else {
-				e.errChan <- fmt.Errorf(
+				e.errChan <- &loggedError{fmt.Errorf(
 					"%s error: %s",
 					err.Kind.String(),
 					err.Err.Error(),
-				)
+				), fields}
 			}
 		} else if err.Err == nil {
-			e.errChan <- fmt.Errorf(
+			e.errChan <- &loggedError{fmt.Errorf(
 				"Unknown %s error on %s",
 				err.Kind.String(),
 				err.Ctx.URL().String(),
-			)
+			), fields}
 		} else {
-			e.errChan <- fmt.Errorf(
+			e.errChan <- &loggedError{fmt.Errorf(
 				"%s error on %s: %s",
 				err.Kind.String(),
 				err.Ctx.URL().String(),
 				err.Err.Error(),
-			)
+			), fields}
 		}
 	}
 }
 
+// Disallowed implements gocrawl.Extender.Disallowed
+// Counts the URLs the website's robots.txt file disallows visiting, and tells
+// the scheduler gocrawl is done with them.
+func (e *Extender) Disallowed(ctx *gocrawl.URLContext) {
+	robotsDenialsMetric.Inc(e.website.Identifier)
+	e.release(ctx)
+}
+
 // Log implements gocrawl.Extender.Log
 // Redirects all log to the extender's logger.
 func (e *Extender) Log(logFlags gocrawl.LogFlags, msgLevel gocrawl.LogFlags, msg string) {
@@ -317,27 +546,121 @@ func (e *Extender) Log(logFlags gocrawl.LogFlags, msgLevel gocrawl.LogFlags, msg
 	}
 }
 
-// abort tells the parent goroutine to abort the crawling with the given reason.
-func (e *Extender) abort(reason string) {
-	e.abortChan <- reason
-}
+// followLinks hands the links found in a page to the scheduler, unless they
+// should not be visited (see accept), or they don't match any of the website's
+// article and listing patterns, if it has any. If the links the page contained
+// during its previous visit are known, the ones that weren't are given a higher
+// priority. Links to pages that don't need to be visited again yet are only
+// skipped once handed to gocrawl (see Filter), along with the redirections.
+// In incremental mode, the links of a page linking only to known articles aren't
+// followed, and the crawl stops once too many consecutive links to known
+// articles were found (see incrementalCrawl).
+func (e *Extender) followLinks(ctx *gocrawl.URLContext, links []string, known map[string]bool) {
+	depth := linkDepth(ctx) + 1
+	var accepted []*url.URL
+	var fresh []bool
+	var newArticles, knownArticles int
+	for _, link := range links {
+		u, err := url.Parse(link)
+		if err != nil {
+			continue
+		}
 
-// urlRelativeToAbsolute takes a goquery selection referring to a single HTML
-// element contaning an URL in one of its attributes, the goquery representation
-// of the complete HTML document, and the name of the attribute containing the
-// URL in the element, and uses it to replace the attribute's value in the element
-// with an absolute URL computed from the document's absolute URL.
-func urlRelativeToAbsolute(el *goquery.Selection, doc *goquery.Document, attrName string) (err error) {
-	// Extract the relative URL and parse it.
-	target, _ := el.Attr(attrName)
-	u, err := url.Parse(target)
-	if err != nil {
+		e.normalizeURL(u)
+		if !e.isFollowed(u) {
+			e.stats.addSkipped(u.String(), skipFiltered)
+			continue
+		}
+		ok, isKnown := e.accept(u, false)
+		if e.incremental != nil && e.scheduler.isArticleLink(u) {
+			if isKnown {
+				knownArticles++
+			} else if ok {
+				newArticles++
+			}
+			if e.incremental.addArticleLink(u.String(), isKnown) {
+				e.log.Infof(
+					"Found %d consecutive links to known articles, stopping the crawl",
+					e.incremental.maxKnown,
+				)
+				e.scheduler.stop()
+			}
+		}
+		if ok {
+			accepted = append(accepted, u)
+			fresh = append(fresh, known != nil && !known[link])
+		}
+	}
+
+	if e.incremental != nil && knownArticles > 0 && newArticles == 0 {
+		e.log.WithField("url", ctx.URL().String()).Debug("Page only links to known articles, not following its links")
 		return
 	}
 
-	// Replace the attribute's value in the element.
-	el.RemoveAttr(attrName)
-	el.SetAttr(attrName, doc.Url.ResolveReference(u).String())
+	for i, u := range accepted {
+		e.scheduler.add(u, depth, fresh[i])
+	}
+}
+
+// isArticlePage checks whether a page can contain an article, i.e. its URL
+// matches one of the website's article patterns, if it has any, and doesn't
+// match any of its listing patterns.
+func (e *Extender) isArticlePage(u *url.URL) bool {
+	if e.website.Filters == nil {
+		return true
+	}
+	if e.website.Filters.Listing.MatchString(u.String()) {
+		return false
+	}
+
+	return e.website.Filters.Article == nil || e.website.Filters.Article.MatchString(u.String())
+}
 
-	return
+// isFollowed checks whether a link matches one of the website's article or
+// listing patterns, if it has any.
+func (e *Extender) isFollowed(u *url.URL) bool {
+	filters := e.website.Filters
+	if filters == nil || (filters.Article == nil && filters.Listing == nil) {
+		return true
+	}
+
+	return filters.Article.MatchString(u.String()) || filters.Listing.MatchString(u.String())
+}
+
+// articlePatterns returns the website's article patterns, or nil if it doesn't
+// have any.
+func articlePatterns(website *config.Website) config.Patterns {
+	if website.Filters == nil {
+		return nil
+	}
+
+	return website.Filters.Article
+}
+
+// release tells the scheduler gocrawl is done with a page, and hands the next
+// links to gocrawl.
+func (e *Extender) release(ctx *gocrawl.URLContext) {
+	e.scheduler.done(ctx)
+	e.feed()
+}
+
+// feed hands the links with the highest priority to gocrawl, as long as it
+// isn't handed too many already. It never blocks, since it can be called from
+// gocrawl's own goroutine (e.g. from Filter), which is the one reading the
+// links: if the channel is full, gocrawl still has links to process, and the
+// remaining links are handed to it later.
+func (e *Extender) feed() {
+	for link := e.scheduler.next(); link != nil; link = e.scheduler.next() {
+		select {
+		case e.EnqueueChan <- gocrawl.S{link.url: &linkState{url: link.url, depth: link.depth, seed: link.seed}}:
+		default:
+			e.scheduler.putBack(link)
+			return
+		}
+	}
+}
+
+// abort tells the parent goroutine to abort the crawling with the given reason.
+func (e *Extender) abort(reason string) {
+	e.abortChan <- reason
 }
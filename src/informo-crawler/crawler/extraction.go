@@ -0,0 +1,228 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"common"
+	"common/config"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// Extraction represents the result of the extraction of a news item from a
+// page.
+type Extraction struct {
+	// Matches contains the number of nodes matched by each of the website's
+	// selectors, identified by their name in the configuration file. Optional
+	// selectors that aren't configured are absent from the map.
+	Matches map[string]int
+	// RawDate is the item's date, as it appears on the page (in the date
+	// node's text, or in the configured attribute).
+	RawDate string
+	// DateFromURL is true if the item's date was read from its URL, because the
+	// page doesn't contain one, or it couldn't be parsed.
+	DateFromURL bool
+	// Article is the extracted news item. It is nil if the page isn't a news
+	// item.
+	Article *common.Article
+	// DateParseFailed is true if the item's date couldn't be parsed using the
+	// website's date formats, nor read from its URL.
+	DateParseFailed bool
+	// Errors contains the non-fatal errors that happened during the extraction,
+	// e.g. if the item's date couldn't be parsed.
+	Errors []error
+}
+
+// Extract looks for a news item in a page using the selectors from a website's
+// configuration, and, if the page contains one, extracts all data available.
+// Also manipulates the content to replace all relative links to absolute ones,
+// and to remove <aside> and <script> nodes.
+// This is the logic used by the Extender when visiting a page, exposed so that
+// it can be run on a single page, without crawling the whole website.
+func Extract(website *config.Website, doc *goquery.Document, pageURL *url.URL) *Extraction {
+	var err error
+	var description, author *string
+	var contentNodes, titleNodes, dateNodes *goquery.Selection
+	var nodes []*html.Node
+
+	extraction := &Extraction{Matches: make(map[string]int)}
+
+	// Find content, title and date using the CSS selectors specified in the
+	// configuration file.
+	// The date can be read from one of the node's attributes rather than from
+	// its text.
+	dateSelector, dateAttr := config.SplitSelectorAttribute(website.Selectors.Date)
+	contentNodes = doc.Find(website.Selectors.Content)
+	titleNodes = doc.Find(website.Selectors.Title)
+	dateNodes = doc.Find(dateSelector)
+
+	extraction.Matches["content"] = len(contentNodes.Nodes)
+	extraction.Matches["title"] = len(titleNodes.Nodes)
+	extraction.Matches["date"] = len(dateNodes.Nodes)
+
+	// Count the matches for the optional selectors too.
+	var descriptionNodes, authorNodes, thumbnailNodes []*html.Node
+	if len(website.Selectors.Description) > 0 {
+		descriptionNodes = doc.Find(website.Selectors.Description).Nodes
+		extraction.Matches["description"] = len(descriptionNodes)
+	}
+	if len(website.Selectors.Author) > 0 {
+		authorNodes = doc.Find(website.Selectors.Author).Nodes
+		extraction.Matches["author"] = len(authorNodes)
+	}
+	if len(website.Selectors.Thumbnail) > 0 {
+		thumbnailNodes = doc.Find(website.Selectors.Thumbnail).Nodes
+		extraction.Matches["thumbnail"] = len(thumbnailNodes)
+	}
+
+	// There should only be one match for content and title. In some weird configurations,
+	// there can be more than one match for the date. This is fine as long as there's at
+	// least one, only the first match will be used. Pages without a date can still be
+	// articles if their URL contains one.
+	// If one of theses requirements isn't met, it means the page isn't an article.
+	urlDate, hasURLDate := dateFromURL(website, pageURL)
+	if len(contentNodes.Nodes) != 1 || len(titleNodes.Nodes) != 1 ||
+		(len(dateNodes.Nodes) == 0 && !hasURLDate) {
+		return extraction
+	}
+
+	// Look for optional data, starting with the description.
+	nodes = descriptionNodes
+	if len(nodes) > 0 {
+		description = new(string)
+		*description = strings.Trim(nodes[0].FirstChild.Data, " \t\n")
+	}
+	// Search for the post's author.
+	nodes = authorNodes
+	if len(nodes) > 0 {
+		author = new(string)
+		if nodes[0].FirstChild.Data == "a" {
+			// Sometimes there's a link on the author's name, so we need to
+			// go deeper into the children to find the text data.
+			*author = strings.Trim(nodes[0].FirstChild.FirstChild.Data, " \t\n")
+		} else {
+			*author = strings.Trim(nodes[0].FirstChild.Data, " \t\n")
+		}
+	}
+	// Search for the thumbnail. If one is found, add it at the very beginning of
+	// the content.
+	nodes = thumbnailNodes
+	if len(nodes) > 0 && nodes[0].Data == "img" {
+		contentNodes.PrependNodes(nodes[0])
+	}
+
+	// Remove useless content and scripts.
+	contentNodes.Find("aside").Remove()
+	contentNodes.Find("script").Remove()
+
+	// Make relative links absolute.
+	contentNodes.Find("a").Map(func(i int, selection *goquery.Selection) (s string) {
+		if err = urlRelativeToAbsolute(selection, doc, "href"); err != nil {
+			extraction.Errors = append(extraction.Errors, err)
+		}
+
+		return
+	})
+	contentNodes.Find("img").Map(func(i int, selection *goquery.Selection) (s string) {
+		if err = urlRelativeToAbsolute(selection, doc, "src"); err != nil {
+			extraction.Errors = append(extraction.Errors, err)
+		}
+
+		return
+	})
+
+	// Extract the HTML content.
+	content, err := contentNodes.Html()
+	if err != nil {
+		extraction.Errors = append(extraction.Errors, err)
+	}
+
+	// Trim unnecessary space, tabs and line breaks.
+	title := strings.Trim(titleNodes.Nodes[0].FirstChild.Data, " \t\n")
+	if len(dateNodes.Nodes) > 0 {
+		if len(dateAttr) > 0 {
+			extraction.RawDate, _ = dateNodes.First().Attr(dateAttr)
+		} else {
+			extraction.RawDate = dateNodes.First().Text()
+		}
+		extraction.RawDate = strings.TrimSpace(extraction.RawDate)
+	}
+	// Convert the date into a time.Time instance so it can be stored as a
+	// timestamp in the database. If it can't be, fall back to the date in the
+	// URL.
+	var dateTime time.Time
+	if len(extraction.RawDate) > 0 {
+		dateTime, err = parseDate(website, extraction.RawDate)
+	} else {
+		err = fmt.Errorf("Empty date")
+	}
+	if err != nil && hasURLDate {
+		dateTime, err = urlDate, nil
+		extraction.DateFromURL = true
+	}
+	if err != nil {
+		extraction.DateParseFailed = true
+		extraction.Errors = append(extraction.Errors, err)
+	}
+
+	// Look for the article's language, using its title and content's text if
+	// the page doesn't declare it.
+	text := contentNodes.Text()
+	var language *string
+	if lang := detectLanguage(doc, title+"\n"+text); len(lang) > 0 {
+		language = &lang
+	}
+
+	extraction.Article = &common.Article{
+		URL:         CanonicalURL(pageURL, doc),
+		Title:       title,
+		Description: description,
+		Content:     content,
+		Author:      author,
+		Date:        dateTime,
+		Language:    language,
+		Fingerprint: fingerprint(text),
+		CrawledAt:   time.Now().UTC(),
+	}
+
+	return extraction
+}
+
+// urlRelativeToAbsolute takes a goquery selection referring to a single HTML
+// element contaning an URL in one of its attributes, the goquery representation
+// of the complete HTML document, and the name of the attribute containing the
+// URL in the element, and uses it to replace the attribute's value in the element
+// with an absolute URL computed from the document's absolute URL.
+func urlRelativeToAbsolute(el *goquery.Selection, doc *goquery.Document, attrName string) (err error) {
+	// Extract the relative URL and parse it.
+	target, _ := el.Attr(attrName)
+	u, err := url.Parse(target)
+	if err != nil {
+		return
+	}
+
+	// Replace the attribute's value in the element.
+	el.RemoveAttr(attrName)
+	el.SetAttr(attrName, doc.Url.ResolveReference(u).String())
+
+	return
+}
@@ -0,0 +1,133 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"common/config"
+
+	"github.com/PuerkitoBio/gocrawl"
+)
+
+// Fetcher describes a component able to retrieve the resource located at a
+// given URL. The Extender relies on a Fetcher to implement
+// gocrawl.Extender.Fetch, which allows changing how pages are retrieved without
+// changing anything else in the crawling process.
+type Fetcher interface {
+	// Fetch retrieves the resource at the given URL, using the given user agent,
+	// and using a HEAD request instead of a GET one if headRequest is true.
+	// Returns an error if the resource couldn't be retrieved.
+	Fetch(u *url.URL, userAgent string, headRequest bool) (*http.Response, error)
+}
+
+// HTTPFetcher implements Fetcher by sending plain HTTP requests. It is the
+// default fetcher.
+type HTTPFetcher struct {
+	session *Session
+	client  *http.Client
+}
+
+// NewHTTPFetcher instantiates a new HTTPFetcher sending requests within the
+// given session. If no session is provided, or if the session doesn't need a
+// specific HTTP client, gocrawl's default HTTP client is used.
+func NewHTTPFetcher(session *Session) *HTTPFetcher {
+	client := gocrawl.HttpClient
+	if session != nil && session.client != nil {
+		client = session.client
+	}
+
+	return &HTTPFetcher{session: session, client: client}
+}
+
+// Fetch implements Fetcher.Fetch
+// Behaves the same way as gocrawl.DefaultExtender.Fetch, except it uses the
+// fetcher's HTTP client and sends the session's headers. Requests for pages
+// that were already visited are sent conditionally.
+func (f *HTTPFetcher) Fetch(u *url.URL, userAgent string, headRequest bool) (*http.Response, error) {
+	req, err := http.NewRequest(requestMethod(headRequest), u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if f.session != nil {
+		f.session.setHeaders(req, userAgent)
+		if !headRequest && !isRobotsURL(u) {
+			f.session.setValidators(req)
+		}
+	} else {
+		req.Header.Set("User-Agent", userAgent)
+	}
+
+	return f.client.Do(req)
+}
+
+// NewFetcher instantiates the fetcher to use when crawling a given website,
+// according to the crawler's and the website's configuration. Requests are sent
+// within the given session (see Session). If responses are being replayed, the
+// website's responses are read from a sub-directory of the replay directory
+// named after the website's identifier. If they are being recorded, they are
+// saved in the same fashion. Requests sent over the network are subject to the
+// limits shared by all crawlers (see PoliteFetcher), and are retried if they
+// fail because of a transient error (see RetryFetcher). If the website's
+// configuration requires it, requests and responses are archived in WARC files.
+// Returns an error if the website requires a fetcher which configuration is
+// missing, or if the fetcher couldn't be instantiated.
+func NewFetcher(
+	cfg config.CrawlerConfig, website *config.Website, session *Session,
+) (fetcher Fetcher, err error) {
+	if len(cfg.ReplayDir) > 0 {
+		return NewReplayFetcher(filepath.Join(cfg.ReplayDir, website.Identifier))
+	}
+
+	fetcher = NewHTTPFetcher(session)
+
+	if website.Render {
+		if fetcher, err = NewChromeFetcher(cfg.Renderer, session, fetcher); err != nil {
+			return
+		}
+	}
+
+	// Politeness applies to every request sent over the network, including the
+	// ones sent by the browser.
+	fetcher = NewPoliteFetcher(cfg, fetcher)
+
+	// Retry the requests that failed because of transient errors if required.
+	if cfg.Retry != nil || cfg.CircuitBreaker != nil {
+		fetcher = NewRetryFetcher(cfg.Retry, cfg.CircuitBreaker, fetcher)
+	}
+
+	if len(cfg.RecordDir) > 0 {
+		if fetcher, err = NewRecordingFetcher(
+			filepath.Join(cfg.RecordDir, website.Identifier), fetcher,
+		); err != nil {
+			return
+		}
+	}
+
+	if website.WARC != nil {
+		fetcher, err = NewWARCFetcher(website.WARC, website.Identifier, fetcher)
+	}
+
+	return
+}
+
+// isRobotsURL checks whether a URL is the one of a website's robots.txt file.
+func isRobotsURL(u *url.URL) bool {
+	return strings.ToLower(u.Path) == "/robots.txt"
+}
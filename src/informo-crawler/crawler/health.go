@@ -0,0 +1,286 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"common"
+)
+
+const (
+	// healthHistorySize is the number of previous runs on a website the
+	// selectors' match rates of the current run are compared to.
+	healthHistorySize = 10
+	// minHealthPages is the minimum number of pages expected to be articles a
+	// run must have visited for its match rates to be meaningful. Runs that
+	// visited fewer of them (e.g. because no new article was published) are
+	// neither flagged as degraded nor used as a reference.
+	minHealthPages = 5
+	// minHealthHistory is the minimum number of previous runs with meaningful
+	// match rates required to compare them. Below that, a website is never
+	// flagged as degraded.
+	minHealthHistory = 3
+	// minTrackedMatchRate is the average match rate above which a selector is
+	// considered to usually match in articles. Only these selectors are
+	// tracked, since the optional ones can legitimately be missing from most
+	// articles.
+	minTrackedMatchRate = 0.5
+	// degradedMatchRatio is the ratio of a selector's average match rate below
+	// which its match rate in the current run is considered as a sharp drop.
+	degradedMatchRatio = 0.5
+)
+
+// skipReason is the reason a URL wasn't enqueued for.
+type skipReason int
+
+const (
+	// skipKnown means the URL is the URL of an article that was already
+	// saved.
+	skipKnown skipReason = iota
+	// skipFiltered means the URL didn't pass the website's filters.
+	skipFiltered
+	// skipRecentlySeen means the page at the URL is neither an article nor a
+	// listing page, and was visited within the website's recheck interval.
+	skipRecentlySeen
+)
+
+// runStats keeps track of the extraction statistics of the current run of the
+// crawler on a website, along with the statistics that are only included in the
+// run's report (see Report). It is safe to use from several goroutines.
+type runStats struct {
+	lock         sync.Mutex
+	run          common.CrawlRun
+	pagesFetched int
+	httpErrors   int
+	// skipped contains the URLs that weren't enqueued, associated with the
+	// reason they were skipped for. Each URL is only counted once, even though
+	// it can be linked from several pages.
+	skipped map[string]skipReason
+	// notModified contains the URLs of the pages that didn't change since
+	// they were last visited, and therefore weren't visited again.
+	notModified map[string]bool
+}
+
+// newRunStats instantiates a new runStats for a given website.
+func newRunStats(website string) *runStats {
+	return &runStats{
+		run: common.CrawlRun{
+			Website:         website,
+			StartedAt:       time.Now().UTC(),
+			SelectorMatches: make(map[string]int),
+		},
+		skipped:     make(map[string]skipReason),
+		notModified: make(map[string]bool),
+	}
+}
+
+// start resets the run's start time to the current time.
+func (s *runStats) start() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.run.StartedAt = time.Now().UTC()
+}
+
+// addVisit updates the statistics with the extraction from a visited page. The
+// selectors' matches are only counted if the page is expected to be an article.
+func (s *runStats) addVisit(extraction *Extraction, articlePage bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.run.PagesVisited++
+	if !articlePage {
+		return
+	}
+
+	s.run.ArticlePagesVisited++
+	for name, matches := range extraction.Matches {
+		if matches > 0 {
+			s.run.SelectorMatches[name]++
+		}
+	}
+	if extraction.DateParseFailed {
+		s.run.DateParseFailures++
+	}
+}
+
+// addFetch updates the statistics with a request sent to the website.
+func (s *runStats) addFetch() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.pagesFetched++
+}
+
+// addHTTPError updates the statistics with a request to the website that failed
+// or got a non-2xx response.
+func (s *runStats) addHTTPError() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.httpErrors++
+}
+
+// addSkipped updates the statistics with a URL that wasn't enqueued for the
+// given reason.
+func (s *runStats) addSkipped(u string, reason skipReason) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.skipped[u] = reason
+}
+
+// addNotModified updates the statistics with a page that didn't change since it
+// was last visited.
+func (s *runStats) addNotModified(u string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.notModified[u] = true
+}
+
+// isNotModified checks whether the page at the given URL didn't change since it
+// was last visited.
+func (s *runStats) isNotModified(u string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.notModified[u]
+}
+
+// addArticle updates the statistics with an article that has been saved.
+func (s *runStats) addArticle() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.run.ArticlesSaved++
+}
+
+// end sets the run's end time to the current time, and returns a copy of the
+// statistics.
+func (s *runStats) end() common.CrawlRun {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.run.EndedAt = time.Now().UTC()
+
+	return s.copyRun()
+}
+
+// setDegraded flags the run as degraded (or not).
+func (s *runStats) setDegraded(degraded bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.run.Degraded = degraded
+}
+
+// report fills a report with the statistics of the run.
+func (s *runStats) report(r *Report) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	r.StartedAt = s.run.StartedAt
+	r.EndedAt = s.run.EndedAt
+	if r.EndedAt.IsZero() {
+		r.EndedAt = time.Now().UTC()
+	}
+	r.Duration = r.EndedAt.Sub(r.StartedAt).Seconds()
+	r.PagesFetched = s.pagesFetched
+	r.PagesVisited = s.run.PagesVisited
+	r.ArticlesSaved = s.run.ArticlesSaved
+	r.HTTPErrors = s.httpErrors
+	r.DateParseFailures = s.run.DateParseFailures
+	r.NotModified = len(s.notModified)
+	r.Degraded = s.run.Degraded
+	for _, reason := range s.skipped {
+		switch reason {
+		case skipKnown:
+			r.SkippedKnown++
+		case skipFiltered:
+			r.FilteredOut++
+		case skipRecentlySeen:
+			r.SkippedSeen++
+		}
+	}
+}
+
+// copyRun returns a copy of the run's statistics. Must be called with the
+// lock held.
+func (s *runStats) copyRun() common.CrawlRun {
+	run := s.run
+	run.SelectorMatches = make(map[string]int, len(s.run.SelectorMatches))
+	for name, matches := range s.run.SelectorMatches {
+		run.SelectorMatches[name] = matches
+	}
+
+	return run
+}
+
+// averageMatchRates computes the average match rate of each selector over the
+// given runs, ignoring the ones that didn't visit enough pages expected to be
+// articles (see minHealthPages).
+// Returns the average match rates and the number of runs they were computed
+// from.
+func averageMatchRates(runs []common.CrawlRun) (avg map[string]float64, n int) {
+	avg = make(map[string]float64)
+	for i := range runs {
+		if runs[i].ArticlePagesVisited < minHealthPages {
+			continue
+		}
+
+		for name, rate := range runs[i].MatchRates() {
+			avg[name] += rate
+		}
+		n++
+	}
+
+	for name := range avg {
+		avg[name] /= float64(n)
+	}
+
+	return
+}
+
+// degradedSelectors returns the names of the selectors which usually match in
+// articles (see minTrackedMatchRate) but which match rate in a run dropped
+// sharply compared to their average match rate over the previous runs on the
+// same website, which usually means the website was redesigned.
+// Returns nil if the run or the previous runs didn't visit enough pages
+// expected to be articles to compare them.
+func degradedSelectors(run *common.CrawlRun, history []common.CrawlRun) (degraded []string) {
+	if run.ArticlePagesVisited < minHealthPages {
+		return nil
+	}
+
+	avg, n := averageMatchRates(history)
+	if n < minHealthHistory {
+		return nil
+	}
+
+	rates := run.MatchRates()
+	for name, avgRate := range avg {
+		if avgRate >= minTrackedMatchRate && rates[name] < avgRate*degradedMatchRatio {
+			degraded = append(degraded, name)
+		}
+	}
+	sort.Strings(degraded)
+
+	return
+}
@@ -0,0 +1,86 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"reflect"
+	"testing"
+
+	"common"
+)
+
+// healthyRun returns a run which visited the given number of pages expected to
+// be articles, in which the title, content and date selectors matched the given
+// number of times, the thumbnail selector matching in none of them.
+func healthyRun(articlePages int, matches int) common.CrawlRun {
+	return common.CrawlRun{
+		PagesVisited:        articlePages + 10,
+		ArticlePagesVisited: articlePages,
+		SelectorMatches: map[string]int{
+			"title":   matches,
+			"content": matches,
+			"date":    matches,
+		},
+	}
+}
+
+func TestDegradedSelectors(t *testing.T) {
+	history := []common.CrawlRun{
+		healthyRun(20, 20), healthyRun(10, 9), healthyRun(30, 30),
+		// Runs that visited too few articles aren't used as a reference.
+		healthyRun(2, 0),
+	}
+
+	tests := []struct {
+		name     string
+		run      common.CrawlRun
+		history  []common.CrawlRun
+		degraded []string
+	}{
+		{"healthy", healthyRun(10, 10), history, nil},
+		// A run which didn't find any new article (e.g. because they were all
+		// already saved) isn't degraded, even though it saved nothing.
+		{"quiet", healthyRun(0, 0), history, nil},
+		{"too few pages", healthyRun(3, 0), history, nil},
+		{"broken", healthyRun(10, 0), history, []string{"content", "date", "title"}},
+		{"content broken", common.CrawlRun{
+			ArticlePagesVisited: 10,
+			SelectorMatches:     map[string]int{"title": 10, "content": 2, "date": 10},
+		}, history, []string{"content"}},
+		{"short history", healthyRun(10, 0), history[:2], nil},
+	}
+
+	for _, test := range tests {
+		if degraded := degradedSelectors(&test.run, test.history); !reflect.DeepEqual(degraded, test.degraded) {
+			t.Errorf("%s: degradedSelectors() = %v, want %v", test.name, degraded, test.degraded)
+		}
+	}
+}
+
+func TestAddVisitOnlyCountsArticlePages(t *testing.T) {
+	s := newRunStats("website")
+	s.addVisit(&Extraction{Matches: map[string]int{"title": 1}}, true)
+	s.addVisit(&Extraction{Matches: map[string]int{"title": 1}}, false)
+	s.addVisit(&Extraction{}, false)
+
+	run := s.end()
+	if run.PagesVisited != 3 || run.ArticlePagesVisited != 1 {
+		t.Errorf("Pages visited = %d, article pages visited = %d", run.PagesVisited, run.ArticlePagesVisited)
+	}
+	if rates := run.MatchRates(); rates["title"] != 1 {
+		t.Errorf("Match rates = %v", rates)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"sync"
+)
+
+// Default number of consecutive links to known articles after which the crawl
+// of a website stops in incremental mode.
+const defaultMaxKnownArticles = 20
+
+// incrementalCrawl keeps track of the links to articles found during a crawl in
+// incremental mode, i.e. a crawl only looking for new articles, to tell when it
+// should stop. It is safe to use from several goroutines.
+type incrementalCrawl struct {
+	lock     sync.Mutex
+	maxKnown int
+	// consecutiveKnown is the number of links to known articles found since
+	// the last link to a new one.
+	consecutiveKnown int
+	// counted contains the links to articles already counted, since the same
+	// link can be found on several pages (e.g. in a "most read" box).
+	counted map[string]bool
+	stopped bool
+}
+
+// newIncrementalCrawl instantiates a new incrementalCrawl, which stops the crawl
+// after a given number of consecutive links to known articles, or after the
+// default number if it is 0.
+func newIncrementalCrawl(maxKnown int) *incrementalCrawl {
+	if maxKnown <= 0 {
+		maxKnown = defaultMaxKnownArticles
+	}
+
+	return &incrementalCrawl{maxKnown: maxKnown, counted: make(map[string]bool)}
+}
+
+// addArticleLink updates the number of consecutive links to known articles with
+// a link to an article, depending on whether the article is known. Links that
+// were already counted are ignored.
+// Returns true the first time the number reaches the maximum, i.e. when the
+// crawl should stop.
+func (c *incrementalCrawl) addArticleLink(u string, known bool) (stop bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.counted[u] {
+		return
+	}
+	c.counted[u] = true
+
+	if !known {
+		c.consecutiveKnown = 0
+		return
+	}
+
+	c.consecutiveKnown++
+	if c.consecutiveKnown >= c.maxKnown && !c.stopped {
+		c.stopped = true
+		return true
+	}
+
+	return
+}
@@ -0,0 +1,287 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"common"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// profileSize is the number of trigrams kept in each language profile, and in
+// the profile computed from the text to classify.
+const profileSize = 300
+
+// minClassifiableLength is the minimal number of letters a text must contain
+// for the classifier to try guessing its language. Below that, the guess is too
+// unreliable to be of any use.
+const minClassifiableLength = 40
+
+// referenceSamples contains, for each language the classifier knows about, a
+// short text written in that language. The trigram profile of each language is
+// computed from it when the package is initialised.
+var referenceSamples = map[string]string{
+	"en": `All human beings are born free and equal in dignity and rights. They
+	are endowed with reason and conscience and should act towards one another in
+	a spirit of brotherhood. The government announced on Monday that the new law
+	would come into force at the beginning of the year, despite the protests of
+	the opposition. According to the minister, the reform will help the economy
+	and create thousands of jobs in the country, which has been hit hard by the
+	crisis. Journalists who were covering the event said that the police had
+	arrested several people during the night.`,
+	"fr": `Tous les êtres humains naissent libres et égaux en dignité et en
+	droits. Ils sont doués de raison et de conscience et doivent agir les uns
+	envers les autres dans un esprit de fraternité. Le gouvernement a annoncé
+	lundi que la nouvelle loi entrerait en vigueur au début de l'année, malgré
+	les protestations de l'opposition. Selon le ministre, la réforme aidera
+	l'économie et créera des milliers d'emplois dans le pays, qui a été durement
+	touché par la crise. Les journalistes qui couvraient l'événement ont déclaré
+	que la police avait arrêté plusieurs personnes pendant la nuit.`,
+	"de": `Alle Menschen sind frei und gleich an Würde und Rechten geboren. Sie
+	sind mit Vernunft und Gewissen begabt und sollen einander im Geist der
+	Brüderlichkeit begegnen. Die Regierung hat am Montag angekündigt, dass das
+	neue Gesetz trotz der Proteste der Opposition zu Beginn des Jahres in Kraft
+	treten wird. Nach Angaben des Ministers wird die Reform der Wirtschaft helfen
+	und tausende Arbeitsplätze in dem Land schaffen, das von der Krise schwer
+	getroffen wurde. Die Journalisten, die über das Ereignis berichteten, sagten,
+	dass die Polizei in der Nacht mehrere Personen festgenommen habe.`,
+	"es": `Todos los seres humanos nacen libres e iguales en dignidad y
+	derechos y, dotados como están de razón y conciencia, deben comportarse
+	fraternalmente los unos con los otros. El gobierno anunció el lunes que la
+	nueva ley entraría en vigor a principios de año, a pesar de las protestas de
+	la oposición. Según el ministro, la reforma ayudará a la economía y creará
+	miles de empleos en el país, que ha sido duramente golpeado por la crisis.
+	Los periodistas que cubrían el evento dijeron que la policía había detenido
+	a varias personas durante la noche.`,
+	"it": `Tutti gli esseri umani nascono liberi ed eguali in dignità e diritti.
+	Essi sono dotati di ragione e di coscienza e devono agire gli uni verso gli
+	altri in spirito di fratellanza. Il governo ha annunciato lunedì che la nuova
+	legge entrerà in vigore all'inizio dell'anno, nonostante le proteste
+	dell'opposizione. Secondo il ministro, la riforma aiuterà l'economia e creerà
+	migliaia di posti di lavoro nel paese, che è stato duramente colpito dalla
+	crisi. I giornalisti che seguivano l'evento hanno detto che la polizia aveva
+	arrestato diverse persone durante la notte.`,
+	"pt": `Todos os seres humanos nascem livres e iguais em dignidade e em
+	direitos. Dotados de razão e de consciência, devem agir uns para com os
+	outros em espírito de fraternidade. O governo anunciou na segunda-feira que
+	a nova lei entraria em vigor no início do ano, apesar dos protestos da
+	oposição. Segundo o ministro, a reforma vai ajudar a economia e criar
+	milhares de empregos no país, que foi duramente atingido pela crise. Os
+	jornalistas que cobriam o evento disseram que a polícia tinha detido várias
+	pessoas durante a noite.`,
+	"nl": `Alle mensen worden vrij en gelijk in waardigheid en rechten geboren.
+	Zij zijn begiftigd met verstand en geweten, en behoren zich jegens elkander
+	in een geest van broederschap te gedragen. De regering heeft maandag
+	aangekondigd dat de nieuwe wet ondanks de protesten van de oppositie aan het
+	begin van het jaar in werking zal treden. Volgens de minister zal de
+	hervorming de economie helpen en duizenden banen scheppen in het land, dat
+	zwaar door de crisis is getroffen. De journalisten die over de gebeurtenis
+	berichtten, zeiden dat de politie tijdens de nacht meerdere mensen had
+	gearresteerd.`,
+	"tr": `Bütün insanlar hür, haysiyet ve haklar bakımından eşit doğarlar.
+	Akıl ve vicdana sahiptirler ve birbirlerine karşı kardeşlik zihniyeti ile
+	hareket etmelidirler. Hükümet pazartesi günü, muhalefetin protestolarına
+	rağmen yeni yasanın yılın başında yürürlüğe gireceğini açıkladı. Bakana göre
+	reform ekonomiye yardımcı olacak ve krizden ağır şekilde etkilenen ülkede
+	binlerce iş yaratacak. Olayı takip eden gazeteciler, polisin gece boyunca
+	birkaç kişiyi gözaltına aldığını söyledi.`,
+	"ru": `Все люди рождаются свободными и равными в своем достоинстве и
+	правах. Они наделены разумом и совестью и должны поступать в отношении друг
+	друга в духе братства. Правительство объявило в понедельник, что новый закон
+	вступит в силу в начале года, несмотря на протесты оппозиции. По словам
+	министра, реформа поможет экономике и создаст тысячи рабочих мест в стране,
+	которая сильно пострадала от кризиса. Журналисты, освещавшие событие,
+	сообщили, что ночью полиция задержала несколько человек.`,
+	"ar": `يولد جميع الناس أحرارا متساوين في الكرامة والحقوق. وقد وهبوا عقلا
+	وضميرا وعليهم أن يعامل بعضهم بعضا بروح الإخاء. أعلنت الحكومة يوم الاثنين أن
+	القانون الجديد سيدخل حيز التنفيذ في بداية العام، على الرغم من احتجاجات
+	المعارضة. وبحسب الوزير، فإن الإصلاح سيساعد الاقتصاد وسيخلق آلاف الوظائف في
+	البلاد التي تضررت بشدة من الأزمة. وقال الصحفيون الذين غطوا الحدث إن الشرطة
+	اعتقلت عدة أشخاص خلال الليل.`,
+	"fa": `تمام افراد بشر آزاد به دنیا می‌آیند و از لحاظ حیثیت و حقوق با هم
+	برابرند. همه دارای عقل و وجدان می‌باشند و باید نسبت به یکدیگر با روح برادری
+	رفتار کنند. دولت روز دوشنبه اعلام کرد که قانون جدید با وجود اعتراض‌های
+	مخالفان از ابتدای سال اجرا خواهد شد. به گفته وزیر، این اصلاحات به اقتصاد
+	کمک می‌کند و هزاران شغل در کشوری که به شدت از بحران آسیب دیده است ایجاد
+	خواهد کرد. خبرنگارانی که این رویداد را پوشش می‌دادند گفتند که پلیس در طول
+	شب چند نفر را بازداشت کرده است.`,
+}
+
+// scriptLanguages maps writing systems that are, in practice, only used by a
+// single language to this language. If most of a text's letters belong to one
+// of these scripts, there's no need to run the trigram classifier.
+var scriptLanguages = []struct {
+	table    *unicode.RangeTable
+	language string
+}{
+	{unicode.Hangul, "ko"},
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Han, "zh"},
+	{unicode.Greek, "el"},
+	{unicode.Hebrew, "he"},
+	{unicode.Thai, "th"},
+	{unicode.Armenian, "hy"},
+	{unicode.Georgian, "ka"},
+}
+
+// languageProfiles contains the trigram profile of each known language, as
+// computed from referenceSamples. Each profile maps a trigram to its rank in
+// the profile.
+var languageProfiles = make(map[string]map[string]int)
+
+func init() {
+	for lang, sample := range referenceSamples {
+		languageProfiles[lang] = trigramProfile(sample)
+	}
+}
+
+// detectLanguage looks for the language of a page. It first looks at the
+// language declared in the page's <html lang="..."> attribute, then at the
+// OpenGraph og:locale property, and if none of these are present, tries to
+// guess the language from the given text using detectTextLanguage.
+// Returns the lowercase ISO 639-1 code of the language (e.g. "fr"), or an empty
+// string if the language couldn't be determined.
+func detectLanguage(doc *goquery.Document, text string) string {
+	// Look at the <html> node's lang attribute.
+	if lang := common.NormaliseLanguageTag(doc.Find("html").First().AttrOr("lang", "")); len(lang) > 0 {
+		return lang
+	}
+
+	// Look at the OpenGraph og:locale property, which uses the "fr_FR" format.
+	if lang := common.NormaliseLanguageTag(
+		doc.Find(`meta[property="og:locale"]`).First().AttrOr("content", ""),
+	); len(lang) > 0 {
+		return lang
+	}
+
+	// Fallback on guessing the language from the content.
+	return detectTextLanguage(text)
+}
+
+// detectTextLanguage guesses the language of a text. If most of its letters are
+// written in a script specific to one language, that language is returned.
+// Otherwise, it uses a trigram classifier (as described by Cavnar and Trenkle in
+// "N-Gram-Based Text Categorization") over the profiles of the languages listed
+// in referenceSamples.
+// Returns an empty string if the text is too short to be classified.
+func detectTextLanguage(text string) string {
+	// Count the letters in the text, and how many of them belong to each
+	// language-specific script.
+	var nbLetters int
+	scriptCounts := make(map[string]int)
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		nbLetters++
+		for _, s := range scriptLanguages {
+			if unicode.Is(s.table, r) {
+				scriptCounts[s.language]++
+				break
+			}
+		}
+	}
+
+	if nbLetters < minClassifiableLength {
+		return ""
+	}
+
+	// Japanese texts contain both kanas and Han characters, so we need to check
+	// kanas first to avoid mistaking them for Chinese texts.
+	if scriptCounts["ja"] > nbLetters/10 {
+		return "ja"
+	}
+	for lang, count := range scriptCounts {
+		if count > nbLetters/2 {
+			return lang
+		}
+	}
+
+	// Compute the text's profile and compare it with each language's profile
+	// using the "out-of-place" measure. The language with the smallest distance
+	// wins.
+	profile := trigramProfile(text)
+	var bestLang string
+	var bestDistance = -1
+	for lang, langProfile := range languageProfiles {
+		var distance int
+		for trigram, rank := range profile {
+			if langRank, ok := langProfile[trigram]; ok {
+				if langRank > rank {
+					distance += langRank - rank
+				} else {
+					distance += rank - langRank
+				}
+			} else {
+				distance += profileSize
+			}
+		}
+
+		if bestDistance < 0 || distance < bestDistance {
+			bestLang = lang
+			bestDistance = distance
+		}
+	}
+
+	return bestLang
+}
+
+// trigramProfile computes the trigram profile of a text, i.e. the profileSize
+// most frequent sequences of three characters in its words (padded with spaces
+// so that the beginning and end of words are taken into account), with their
+// rank (0 being the most frequent).
+func trigramProfile(text string) map[string]int {
+	counts := make(map[string]int)
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, word := range words {
+		runes := []rune(" " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			counts[string(runes[i:i+3])]++
+		}
+	}
+
+	// Sort the trigrams by descending frequency, and alphabetically when two
+	// trigrams have the same frequency so the profile is deterministic.
+	trigrams := make([]string, 0, len(counts))
+	for trigram := range counts {
+		trigrams = append(trigrams, trigram)
+	}
+	sort.Slice(trigrams, func(i, j int) bool {
+		if counts[trigrams[i]] != counts[trigrams[j]] {
+			return counts[trigrams[i]] > counts[trigrams[j]]
+		}
+		return trigrams[i] < trigrams[j]
+	})
+
+	if len(trigrams) > profileSize {
+		trigrams = trigrams[:profileSize]
+	}
+
+	profile := make(map[string]int, len(trigrams))
+	for rank, trigram := range trigrams {
+		profile[trigram] = rank
+	}
+
+	return profile
+}
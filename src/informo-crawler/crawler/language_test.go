@@ -0,0 +1,90 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestDetectTextLanguage(t *testing.T) {
+	tests := []struct {
+		lang string
+		text string
+	}{
+		{"en", "The mayor said on Tuesday that the city would open two new schools next year, after months of negotiations with the unions."},
+		{"fr", "Le maire a déclaré mardi que la ville ouvrirait deux nouvelles écoles l'année prochaine, après des mois de négociations avec les syndicats."},
+		{"de", "Der Bürgermeister sagte am Dienstag, dass die Stadt im nächsten Jahr nach monatelangen Verhandlungen mit den Gewerkschaften zwei neue Schulen eröffnen werde."},
+		{"es", "El alcalde dijo el martes que la ciudad abrirá dos nuevas escuelas el próximo año, después de meses de negociaciones con los sindicatos."},
+		{"it", "Il sindaco ha detto martedì che la città aprirà due nuove scuole il prossimo anno, dopo mesi di trattative con i sindacati."},
+		{"pt", "O prefeito disse na terça-feira que a cidade vai abrir duas novas escolas no próximo ano, depois de meses de negociações com os sindicatos."},
+		{"nl", "De burgemeester zei dinsdag dat de stad volgend jaar twee nieuwe scholen zal openen, na maanden van onderhandelingen met de vakbonden."},
+		{"tr", "Belediye başkanı salı günü yaptığı açıklamada, sendikalarla aylarca süren müzakerelerin ardından şehrin gelecek yıl iki yeni okul açacağını söyledi."},
+		{"ru", "Мэр заявил во вторник, что после многомесячных переговоров с профсоюзами в следующем году в городе откроются две новые школы."},
+		{"ar", "قال رئيس البلدية يوم الثلاثاء إن المدينة ستفتح مدرستين جديدتين في العام المقبل بعد أشهر من المفاوضات مع النقابات."},
+		{"fa", "شهردار روز سه‌شنبه گفت که شهر پس از ماه‌ها مذاکره با اتحادیه‌ها، سال آینده دو مدرسه جدید افتتاح خواهد کرد."},
+		{"ja", "市長は火曜日、労働組合との数か月にわたる交渉の末、来年市内に新しい学校を二つ開校すると発表しました。"},
+		{"zh", "市长星期二表示，经过与工会数月的谈判，该市明年将开设两所新学校，以满足不断增长的入学需求和家长的期望。"},
+		{"ko", "시장은 화요일 노동조합과 수개월간의 협상 끝에 내년에 시내에 새로운 학교 두 곳을 열 것이라고 밝혔다."},
+		{"el", "Ο δήμαρχος δήλωσε την Τρίτη ότι η πόλη θα ανοίξει δύο νέα σχολεία τον επόμενο χρόνο, μετά από μήνες διαπραγματεύσεων."},
+		{"", "Too short to tell."},
+	}
+
+	// Make sure every reference language is covered.
+	covered := make(map[string]bool)
+	for _, test := range tests {
+		covered[test.lang] = true
+	}
+	for lang := range referenceSamples {
+		if !covered[lang] {
+			t.Errorf("No test for reference language %s", lang)
+		}
+	}
+
+	for _, test := range tests {
+		if lang := detectTextLanguage(test.text); lang != test.lang {
+			t.Errorf("detectTextLanguage(%q) = %q, want %q", test.text, lang, test.lang)
+		}
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	englishText := "The mayor said on Tuesday that the city would open two new schools next year, after months of negotiations with the unions."
+
+	tests := []struct {
+		name string
+		head string
+		lang string
+	}{
+		{"html lang", `<html lang="fr-FR"><head><meta property="og:locale" content="de_DE"></head>`, "fr"},
+		{"og:locale", `<html><head><meta property="og:locale" content="de_DE"></head>`, "de"},
+		{"invalid html lang", `<html lang="x"><head><meta property="og:locale" content="es_ES"></head>`, "es"},
+		{"text", `<html><head></head>`, "en"},
+	}
+
+	for _, test := range tests {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(test.head + "<body></body></html>"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if lang := detectLanguage(doc, englishText); lang != test.lang {
+			t.Errorf("%s: detectLanguage() = %q, want %q", test.name, lang, test.lang)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	"common/metrics"
+)
+
+// Metrics exposed by the crawler.
+var (
+	pagesFetchedMetric = metrics.NewCounter(
+		"informo_crawler_pages_fetched_total",
+		"Number of requests sent to websites, including robots.txt files.",
+		"website",
+	)
+	responsesMetric = metrics.NewCounter(
+		"informo_crawler_http_responses_total",
+		"Number of HTTP responses received from websites, by status code.",
+		"website", "code",
+	)
+	responseBytesMetric = metrics.NewCounter(
+		"informo_crawler_response_bytes_total",
+		"Number of bytes read from the bodies of websites' responses.",
+		"website",
+	)
+	robotsDenialsMetric = metrics.NewCounter(
+		"informo_crawler_robots_denials_total",
+		"Number of URLs that weren't visited because robots.txt disallowed it.",
+		"website",
+	)
+	articlesSavedMetric = metrics.NewCounter(
+		"informo_crawler_articles_saved_total",
+		"Number of articles saved in the database.",
+		"website",
+	)
+	errorsMetric = metrics.NewCounter(
+		"informo_crawler_errors_total",
+		"Number of errors reported while crawling, by kind of error.",
+		"website", "kind",
+	)
+)
+
+// observeResponse updates the metrics with a response from a website, and wraps
+// its body so the bytes read from it are counted.
+func observeResponse(website string, res *http.Response) {
+	responsesMetric.Inc(website, strconv.Itoa(res.StatusCode))
+	if res.Body != nil {
+		res.Body = &countingBody{ReadCloser: res.Body, website: website}
+	}
+}
+
+// countingBody is an io.ReadCloser adding the bytes read from an underlying
+// io.ReadCloser to the response bytes metric.
+type countingBody struct {
+	io.ReadCloser
+	website string
+}
+
+// Read implements io.Reader.Read
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	responseBytesMetric.Add(float64(n), b.website)
+	return n, err
+}
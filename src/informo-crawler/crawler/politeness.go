@@ -0,0 +1,192 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"common/config"
+)
+
+// hostGates contains the gate of each host crawled by any crawler, so that
+// websites sharing a host share its politeness constraints.
+var (
+	hostGates     = make(map[string]*hostGate)
+	hostGatesLock sync.Mutex
+)
+
+// bandwidth limits the rate at which responses' bodies are read across all
+// crawlers. It is initialised with the first PoliteFetcher, with a rate defined
+// in the configuration, and stays nil if the bandwidth isn't limited.
+var (
+	bandwidth     *bandwidthLimiter
+	bandwidthOnce sync.Once
+)
+
+// hostGate limits the number of requests sent at the same time to a host, and
+// spaces them by a minimum delay.
+type hostGate struct {
+	slots chan struct{}
+	lock  sync.Mutex
+	next  time.Time
+}
+
+// getHostGate returns the gate for a given host, creating it with the given
+// maximum number of concurrent requests (0 meaning no limit) if it doesn't
+// exist.
+func getHostGate(host string, maxFetches int) *hostGate {
+	hostGatesLock.Lock()
+	defer hostGatesLock.Unlock()
+
+	gate, exists := hostGates[host]
+	if !exists {
+		gate = new(hostGate)
+		if maxFetches > 0 {
+			gate.slots = make(chan struct{}, maxFetches)
+		}
+		hostGates[host] = gate
+	}
+
+	return gate
+}
+
+// enter waits until a request can be sent to the host, i.e. until a slot is
+// available and the given delay has passed since the previous request.
+func (g *hostGate) enter(delay time.Duration) {
+	if g.slots != nil {
+		g.slots <- struct{}{}
+	}
+
+	// Holding the lock while waiting makes sure requests are sent in the order
+	// they entered the gate, and spaced by the delay.
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if wait := g.next.Sub(time.Now()); wait > 0 {
+		time.Sleep(wait)
+	}
+	g.next = time.Now().Add(delay)
+}
+
+// leave frees the slot taken when entering the gate.
+func (g *hostGate) leave() {
+	if g.slots != nil {
+		<-g.slots
+	}
+}
+
+// PoliteFetcher implements Fetcher by fetching resources using another Fetcher,
+// while enforcing limits shared by all crawlers: a minimum delay between two
+// requests to the same host, a maximum number of concurrent requests to the same
+// host, and a maximum bandwidth. This prevents websites sharing a host (e.g. the
+// sections of a single news outlet) from multiplying the request rate on it.
+type PoliteFetcher struct {
+	fetcher    Fetcher
+	delay      time.Duration
+	maxFetches int
+}
+
+// NewPoliteFetcher instantiates a new PoliteFetcher using the limits defined in
+// the crawler's configuration, and wrapping the given fetcher.
+func NewPoliteFetcher(cfg config.CrawlerConfig, fetcher Fetcher) *PoliteFetcher {
+	bandwidthOnce.Do(func() {
+		if cfg.MaxBandwidth > 0 {
+			bandwidth = newBandwidthLimiter(cfg.MaxBandwidth * 1024)
+		}
+	})
+
+	return &PoliteFetcher{
+		fetcher:    fetcher,
+		delay:      cfg.CrawlDelay * time.Second,
+		maxFetches: cfg.MaxFetchesPerHost,
+	}
+}
+
+// Fetch implements Fetcher.Fetch
+// Waits for the host's politeness constraints to be met before fetching the
+// resource. The slot taken on the host is freed once the response's headers
+// have been received, and the response's body is read at the allowed
+// bandwidth.
+func (f *PoliteFetcher) Fetch(u *url.URL, userAgent string, headRequest bool) (*http.Response, error) {
+	gate := getHostGate(strings.ToLower(u.Hostname()), f.maxFetches)
+	gate.enter(f.delay)
+	res, err := f.fetcher.Fetch(u, userAgent, headRequest)
+	gate.leave()
+
+	if res != nil && res.Body != nil && bandwidth != nil {
+		res.Body = &limitedBody{ReadCloser: res.Body, limiter: bandwidth}
+	}
+
+	return res, err
+}
+
+// bandwidthLimiter limits the rate at which bytes are read, using a token
+// bucket which size allows bursts of one second. It is safe to use from several
+// goroutines.
+type bandwidthLimiter struct {
+	rate      float64
+	lock      sync.Mutex
+	available float64
+	last      time.Time
+}
+
+// newBandwidthLimiter instantiates a new bandwidthLimiter allowing the given
+// number of bytes per second.
+func newBandwidthLimiter(rate int64) *bandwidthLimiter {
+	return &bandwidthLimiter{
+		rate:      float64(rate),
+		available: float64(rate),
+		last:      time.Now(),
+	}
+}
+
+// take removes n bytes from the bucket, and waits until the bucket isn't in
+// debt anymore.
+func (b *bandwidthLimiter) take(n int) {
+	b.lock.Lock()
+	now := time.Now()
+	b.available += now.Sub(b.last).Seconds() * b.rate
+	if b.available > b.rate {
+		b.available = b.rate
+	}
+	b.last = now
+	b.available -= float64(n)
+	debt := b.available
+	b.lock.Unlock()
+
+	if debt < 0 {
+		time.Sleep(time.Duration(-debt / b.rate * float64(time.Second)))
+	}
+}
+
+// limitedBody is an io.ReadCloser reading from an underlying io.ReadCloser at
+// the rate allowed by a bandwidthLimiter.
+type limitedBody struct {
+	io.ReadCloser
+	limiter *bandwidthLimiter
+}
+
+// Read implements io.Reader.Read
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.limiter.take(n)
+	return n, err
+}
@@ -0,0 +1,219 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/PuerkitoBio/gocrawl"
+)
+
+// recordIndexFile is the name of the file, in a recording directory, listing
+// the recorded requests along with the name of the file their response is
+// stored in.
+const recordIndexFile = "index.txt"
+
+// RecordingFetcher implements Fetcher by fetching resources using another
+// Fetcher, and saving every response it gets in a directory, so they can later
+// be replayed by a ReplayFetcher.
+// Each response is stored, as it was sent on the wire (headers and body), in a
+// file which name is computed from the request's method and URL.
+type RecordingFetcher struct {
+	dir       string
+	fetcher   Fetcher
+	indexLock sync.Mutex
+}
+
+// NewRecordingFetcher instantiates a new RecordingFetcher saving the responses
+// of the given fetcher in the given directory, which is created if it doesn't
+// exist.
+// Returns an error if the directory couldn't be created.
+func NewRecordingFetcher(dir string, fetcher Fetcher) (*RecordingFetcher, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &RecordingFetcher{
+		dir:     dir,
+		fetcher: fetcher,
+	}, nil
+}
+
+// Fetch implements Fetcher.Fetch
+// Fetches the resource using the underlying fetcher and records its response,
+// including if it's a redirection. Responses to requests that failed for any
+// other reason aren't recorded.
+// Returns an error if fetching the resource or recording the response failed.
+func (f *RecordingFetcher) Fetch(u *url.URL, userAgent string, headRequest bool) (*http.Response, error) {
+	res, fetchErr := f.fetcher.Fetch(u, userAgent, headRequest)
+	if res == nil || (fetchErr != nil && !isEnqueueRedirect(fetchErr)) {
+		return res, fetchErr
+	}
+
+	// The body of redirections has already been closed by the HTTP client, so
+	// we record them with an empty one.
+	if fetchErr != nil {
+		res.Body = ioutil.NopCloser(bytes.NewReader(nil))
+		res.ContentLength = 0
+		res.TransferEncoding = nil
+		res.Header.Del("Content-Length")
+	}
+
+	dump, err := httputil.DumpResponse(res, true)
+	if err != nil {
+		return nil, err
+	}
+
+	method := requestMethod(headRequest)
+	name := recordName(method, u)
+	if err = ioutil.WriteFile(filepath.Join(f.dir, name), dump, 0644); err != nil {
+		return nil, err
+	}
+	if err = f.appendToIndex(name, method, u); err != nil {
+		return nil, err
+	}
+
+	return res, fetchErr
+}
+
+// appendToIndex adds a line to the directory's index file, telling which file
+// the response to a request is stored in. The index isn't used when replaying
+// responses, but it makes it easier to find a given recorded response.
+// Returns an error if the index file couldn't be opened or written to.
+func (f *RecordingFetcher) appendToIndex(name string, method string, u *url.URL) error {
+	f.indexLock.Lock()
+	defer f.indexLock.Unlock()
+
+	index, err := os.OpenFile(
+		filepath.Join(f.dir, recordIndexFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644,
+	)
+	if err != nil {
+		return err
+	}
+
+	if _, err = fmt.Fprintf(index, "%s %s %s\n", name, method, u.String()); err != nil {
+		index.Close()
+		return err
+	}
+
+	return index.Close()
+}
+
+// ReplayFetcher implements Fetcher by reading the responses recorded by a
+// RecordingFetcher instead of sending requests over the network, which allows
+// crawling a website in a deterministic way, without network access.
+type ReplayFetcher struct {
+	dir string
+}
+
+// NewReplayFetcher instantiates a new ReplayFetcher reading responses from the
+// given directory.
+// Returns an error if the directory doesn't exist.
+func NewReplayFetcher(dir string) (*ReplayFetcher, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s isn't a directory", dir)
+	}
+
+	return &ReplayFetcher{dir: dir}, nil
+}
+
+// Fetch implements Fetcher.Fetch
+// Reads the response recorded for the request. If the recorded response is a
+// redirection, returns the same error as gocrawl's HTTP client would so gocrawl
+// enqueues the redirection's target.
+// Returns an error if no response was recorded for this request, or if the
+// recorded response couldn't be read.
+func (f *ReplayFetcher) Fetch(u *url.URL, userAgent string, headRequest bool) (*http.Response, error) {
+	method := requestMethod(headRequest)
+	file, err := os.Open(filepath.Join(f.dir, recordName(method, u)))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("No recorded response for %s %s", method, u.String())
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	res, err := http.ReadResponse(bufio.NewReader(file), req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the whole body now, since the file will be closed when returning.
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	// Mimic the behaviour of gocrawl's HTTP client on redirections.
+	if res.StatusCode >= 300 && res.StatusCode < 400 {
+		if location, err := res.Location(); err == nil {
+			return res, &url.Error{
+				Op:  method,
+				URL: location.String(),
+				Err: gocrawl.ErrEnqueueRedirect,
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// isEnqueueRedirect checks whether an error returned by gocrawl's HTTP client
+// means the request was redirected, and the redirection's target should be
+// enqueued.
+func isEnqueueRedirect(err error) bool {
+	urlErr, ok := err.(*url.Error)
+	return ok && urlErr.Err == gocrawl.ErrEnqueueRedirect
+}
+
+// requestMethod returns the HTTP method to use for a request, according to
+// whether it should be a HEAD request.
+func requestMethod(headRequest bool) string {
+	if headRequest {
+		return "HEAD"
+	}
+	return "GET"
+}
+
+// recordName computes the name of the file in which the response to a request
+// with the given method and URL is recorded.
+func recordName(method string, u *url.URL) string {
+	hash := sha1.Sum([]byte(method + " " + u.String()))
+	return hex.EncodeToString(hash[:]) + ".http"
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PuerkitoBio/gocrawl"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/article", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Test", "yes")
+		w.Write([]byte("<html><body>Article</body></html>"))
+	})
+	mux.HandleFunc("/old", func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, "/article", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, req *http.Request) {
+		http.NotFound(w, req)
+	})
+	server := httptest.NewServer(mux)
+
+	dir, err := ioutil.TempDir("", "informo-recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Redirections aren't followed by gocrawl's HTTP client, which returns
+	// ErrEnqueueRedirect instead.
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return gocrawl.ErrEnqueueRedirect
+		},
+	}
+	recorder, err := NewRecordingFetcher(dir, &HTTPFetcher{client: client})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pageURL := func(path string) *url.URL {
+		u, err := url.Parse(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+
+	for _, path := range []string{"/article", "/old", "/missing"} {
+		res, err := recorder.Fetch(pageURL(path), "TestAgent", false)
+		if err != nil && !isEnqueueRedirect(err) {
+			t.Fatalf("Recording %s: %v", path, err)
+		}
+		res.Body.Close()
+	}
+	if _, err = os.Stat(filepath.Join(dir, recordIndexFile)); err != nil {
+		t.Errorf("Index wasn't written: %v", err)
+	}
+
+	// Replay the responses without the server.
+	server.Close()
+	replayer, err := NewReplayFetcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := replayer.Fetch(pageURL("/article"), "TestAgent", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	if string(body) != "<html><body>Article</body></html>" {
+		t.Errorf("Body = %q", body)
+	}
+	if res.StatusCode != http.StatusOK || res.Header.Get("X-Test") != "yes" {
+		t.Errorf("Status = %d, headers = %v", res.StatusCode, res.Header)
+	}
+	if res.Request == nil || res.Request.URL.String() != pageURL("/article").String() {
+		t.Errorf("Response's request isn't set")
+	}
+
+	res, err = replayer.Fetch(pageURL("/old"), "TestAgent", false)
+	if !isEnqueueRedirect(err) {
+		t.Fatalf("Redirection wasn't emulated: %v", err)
+	}
+	if target := err.(*url.Error).URL; target != pageURL("/article").String() {
+		t.Errorf("Redirection target = %s", target)
+	}
+	if res.StatusCode != http.StatusMovedPermanently {
+		t.Errorf("Redirection status = %d", res.StatusCode)
+	}
+
+	res, err = replayer.Fetch(pageURL("/missing"), "TestAgent", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("Status = %d, want %d", res.StatusCode, http.StatusNotFound)
+	}
+
+	// Requests which response wasn't recorded fail.
+	if _, err = replayer.Fetch(pageURL("/other"), "TestAgent", false); err == nil {
+		t.Error("Unrecorded request didn't fail")
+	}
+	if _, err = replayer.Fetch(pageURL("/article"), "TestAgent", true); err == nil {
+		t.Error("Unrecorded HEAD request didn't fail")
+	}
+}
+
+func TestNewReplayFetcherMissingDir(t *testing.T) {
+	if _, err := NewReplayFetcher("/nonexistent/informo-recording"); err == nil {
+		t.Error("Missing directory didn't fail")
+	}
+}
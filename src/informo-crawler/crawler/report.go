@@ -0,0 +1,82 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"time"
+)
+
+// Report represents the summary of a run of a crawler on a website.
+type Report struct {
+	Website   string    `json:"website"`
+	RunID     string    `json:"run_id"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at"`
+	// Duration of the run, in seconds.
+	Duration float64 `json:"duration"`
+	// Number of requests sent to the website, including the one for its
+	// robots.txt file.
+	PagesFetched int `json:"pages_fetched"`
+	// Number of pages that were parsed looking for an article.
+	PagesVisited  int `json:"pages_visited"`
+	ArticlesSaved int `json:"articles_saved"`
+	// Number of URLs that weren't visited because an article with the same URL
+	// was already saved.
+	SkippedKnown int `json:"skipped_known"`
+	// Number of URLs that weren't visited because of the website's restrict
+	// and exclude filters.
+	FilteredOut int `json:"filtered_out"`
+	// Number of URLs that weren't visited because they were visited within the
+	// website's recheck interval, and are neither articles nor listing pages.
+	SkippedSeen int `json:"skipped_seen"`
+	// Number of pages that weren't visited because they didn't change since
+	// they were last visited.
+	NotModified int `json:"not_modified"`
+	// Number of requests that failed or got a non-2xx response, other than
+	// the ones for pages that didn't change.
+	HTTPErrors        int  `json:"http_errors"`
+	DateParseFailures int  `json:"date_parse_failures"`
+	Degraded          bool `json:"degraded"`
+	// Failed is true if the crawl stopped because of an error, if no page
+	// could be visited (unless they didn't change), or if the website was
+	// flagged as degraded. In that case, Reason explains why.
+	Failed bool   `json:"failed"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Report builds the summary of the crawler's run. It must be called once Run
+// has returned.
+func (c *Crawler) Report() *Report {
+	r := &Report{
+		Website: c.website.Identifier,
+		RunID:   c.runID,
+	}
+	c.ext.stats.report(r)
+
+	switch {
+	case c.err != nil:
+		r.Failed = true
+		r.Reason = c.err.Error()
+	case r.PagesVisited == 0 && r.NotModified == 0:
+		r.Failed = true
+		r.Reason = "No page could be visited"
+	case r.Degraded:
+		r.Failed = true
+		r.Reason = "Selectors' match rates dropped sharply, the website's selectors might be broken"
+	}
+
+	return r
+}
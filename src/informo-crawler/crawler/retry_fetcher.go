@@ -0,0 +1,387 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"common/config"
+)
+
+const (
+	// defaultInitialBackoff is the time waited before retrying a request for
+	// the first time if no initial backoff is provided in the configuration.
+	defaultInitialBackoff = time.Second
+	// defaultMaxBackoff is the maximum time waited before retrying a request if
+	// no maximum backoff is provided in the configuration.
+	defaultMaxBackoff = time.Minute
+	// defaultBreakerPause is the time requests to a host are paused for once
+	// its circuit breaker trips, if no pause is provided in the configuration.
+	defaultBreakerPause = time.Minute
+)
+
+// hostBreakers contains the circuit breaker of each host crawled by any
+// crawler, so that websites sharing a host share its failures.
+var (
+	hostBreakers     = make(map[string]*breaker)
+	hostBreakersLock sync.Mutex
+)
+
+// breakerError is the error returned for requests to a host which circuit
+// breaker has tripped too many times, or which asked not to be requested again
+// until a given time, too far away to wait for it (see breaker.block).
+type breakerError struct {
+	host  string
+	trips int
+	until time.Time
+}
+
+// Error implements error.Error
+func (e *breakerError) Error() string {
+	if !e.until.IsZero() {
+		return fmt.Sprintf(
+			"%s asked not to be requested again until %s", e.host, e.until.Format(time.RFC3339),
+		)
+	}
+
+	return fmt.Sprintf("Too many failed requests to %s (paused %d times)", e.host, e.trips)
+}
+
+// isBreakerError checks whether an error was returned because a host's circuit
+// breaker tripped too many times, or because the host asked not to be requested
+// for too long, including if it's wrapped in a *url.Error.
+func isBreakerError(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+
+	_, ok := err.(*breakerError)
+	return ok
+}
+
+// breaker represents the circuit breaker of a host. It counts the consecutive
+// failed requests to the host, and trips once they reach a given threshold,
+// which pauses all requests to the host for a given time. Once it has tripped a
+// given number of times, all requests to the host fail, and so do they until a
+// given time once the breaker is blocked. It is safe to use from several
+// goroutines.
+type breaker struct {
+	host         string
+	lock         sync.Mutex
+	failures     int
+	trips        int
+	until        time.Time
+	blockedUntil time.Time
+}
+
+// getBreaker returns the circuit breaker for a given host, creating it if it
+// doesn't exist.
+func getBreaker(host string) *breaker {
+	hostBreakersLock.Lock()
+	defer hostBreakersLock.Unlock()
+
+	b, exists := hostBreakers[host]
+	if !exists {
+		b = &breaker{host: host}
+		hostBreakers[host] = b
+	}
+
+	return b
+}
+
+// wait waits until requests to the host aren't paused anymore.
+// Returns a *breakerError if the breaker has tripped more than the given
+// maximum number of times (0 meaning no limit), or if it is blocked.
+func (b *breaker) wait(maxTrips int) error {
+	b.lock.Lock()
+	trips := b.trips
+	pause := b.until.Sub(time.Now())
+	blockedUntil := b.blockedUntil
+	b.lock.Unlock()
+
+	if maxTrips > 0 && trips > maxTrips {
+		return &breakerError{host: b.host, trips: trips}
+	}
+	if time.Now().Before(blockedUntil) {
+		return &breakerError{host: b.host, trips: trips, until: blockedUntil}
+	}
+	if pause > 0 {
+		time.Sleep(pause)
+	}
+
+	return nil
+}
+
+// pause pauses all requests to the host for a given time, unless they're
+// already paused for longer, without counting it as a trip.
+func (b *breaker) pause(d time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if until := time.Now().Add(d); until.After(b.until) {
+		b.until = until
+	}
+}
+
+// block makes all requests to the host fail until a given time, unless they
+// already do for longer, without counting it as a trip.
+func (b *breaker) block(until time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if until.After(b.blockedUntil) {
+		b.blockedUntil = until
+	}
+}
+
+// record records the result of a request to the host, and trips the breaker if
+// the number of consecutive failures reaches the given threshold.
+// Returns true if the breaker tripped.
+func (b *breaker) record(failed bool, threshold int, pause time.Duration) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if !failed {
+		b.failures = 0
+		return false
+	}
+
+	b.failures++
+	if b.failures < threshold {
+		return false
+	}
+
+	b.failures = 0
+	b.trips++
+	b.until = time.Now().Add(pause)
+	return true
+}
+
+// RetryFetcher implements Fetcher by fetching resources using another Fetcher,
+// and retrying requests that failed because of a transient error (a network
+// error, a 5xx status code or a 429 status code) with an exponential backoff.
+// If the response has a Retry-After header, it is used instead of the backoff.
+// If it exceeds the maximum backoff, the request isn't retried, and is counted
+// as a failure by the circuit breaker of its host, if enabled. All requests to
+// the host are then paused for the time the website asked for, if it doesn't
+// exceed the breaker's pause either (or the maximum backoff if breakers are
+// disabled), or fail until then otherwise, which aborts the crawl instead of
+// blocking it for that long.
+// Requests which failed even after being retried are counted by the circuit
+// breaker of their host, if enabled.
+type RetryFetcher struct {
+	fetcher        Fetcher
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	breaker        *config.BreakerConfig
+	breakerPause   time.Duration
+}
+
+// NewRetryFetcher instantiates a new RetryFetcher using the given retry and
+// circuit breaker configurations, each of which can be nil to disable it, and
+// wrapping the given fetcher.
+func NewRetryFetcher(
+	retryCfg *config.RetryConfig, breakerCfg *config.BreakerConfig, fetcher Fetcher,
+) *RetryFetcher {
+	f := &RetryFetcher{
+		fetcher:        fetcher,
+		initialBackoff: defaultInitialBackoff,
+		maxBackoff:     defaultMaxBackoff,
+		breaker:        breakerCfg,
+		breakerPause:   defaultBreakerPause,
+	}
+
+	if retryCfg != nil {
+		f.maxRetries = retryCfg.MaxRetries
+		if retryCfg.InitialBackoff > 0 {
+			f.initialBackoff = retryCfg.InitialBackoff * time.Second
+		}
+		if retryCfg.MaxBackoff > 0 {
+			f.maxBackoff = retryCfg.MaxBackoff * time.Second
+		}
+	}
+	if breakerCfg != nil && breakerCfg.Pause > 0 {
+		f.breakerPause = breakerCfg.Pause * time.Second
+	}
+
+	return f
+}
+
+// Fetch implements Fetcher.Fetch
+// Returns the last response or error if the request still failed after being
+// retried, or a *breakerError if the host's circuit breaker tripped too many
+// times, or if the host asked not to be requested for too long.
+func (f *RetryFetcher) Fetch(u *url.URL, userAgent string, headRequest bool) (res *http.Response, err error) {
+	// The host's breaker is used to honour Retry-After headers even if circuit
+	// breakers are disabled, in which case it never trips.
+	b := getBreaker(strings.ToLower(u.Hostname()))
+	breakerEnabled := f.breaker != nil && f.breaker.Threshold > 0
+	var maxTrips int
+	if breakerEnabled {
+		maxTrips = f.breaker.MaxTrips
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err = b.wait(maxTrips); err != nil {
+			return nil, &url.Error{Op: requestMethod(headRequest), URL: u.String(), Err: err}
+		}
+
+		res, err = f.fetcher.Fetch(u, userAgent, headRequest)
+		if !isTransientFailure(res, err) {
+			break
+		}
+
+		// Retrying earlier than the website asked would only add to its load,
+		// so the request isn't retried if it asked to wait longer than the
+		// maximum backoff. Waiting for longer than a breaker's pause would
+		// block the crawl, so it is aborted instead.
+		if delay, ok := retryAfter(res); ok && delay > f.maxBackoff {
+			maxPause := f.maxBackoff
+			if breakerEnabled {
+				b.record(true, f.breaker.Threshold, f.breakerPause)
+				if f.breakerPause > maxPause {
+					maxPause = f.breakerPause
+				}
+			}
+			if delay <= maxPause {
+				b.pause(delay)
+				return
+			}
+
+			res.Body.Close()
+			until := time.Now().Add(delay)
+			b.block(until)
+			return nil, &url.Error{
+				Op:  requestMethod(headRequest),
+				URL: u.String(),
+				Err: &breakerError{host: b.host, until: until},
+			}
+		}
+
+		if attempt >= f.maxRetries {
+			if breakerEnabled && b.record(true, f.breaker.Threshold, f.breakerPause) {
+				// The caller doesn't know about the breaker, so we need to
+				// make sure the pause is visible in the logs.
+				err = retryError(res, err, fmt.Sprintf(
+					"%s paused for %s after %d consecutive failures",
+					b.host, f.breakerPause, f.breaker.Threshold,
+				))
+				if res != nil {
+					res.Body.Close()
+					res = nil
+				}
+			}
+			return
+		}
+
+		// Wait before retrying, and discard the failed response.
+		delay := f.backoff(attempt, res)
+		if res != nil {
+			res.Body.Close()
+		}
+		time.Sleep(delay)
+	}
+
+	if breakerEnabled {
+		b.record(false, f.breaker.Threshold, f.breakerPause)
+	}
+
+	return
+}
+
+// backoff computes the time to wait before retrying a request for the given
+// attempt (starting at 0), using the response's Retry-After header if there's
+// one (Fetch doesn't retry requests if it exceeds the maximum backoff), or an
+// exponential backoff with jitter otherwise.
+func (f *RetryFetcher) backoff(attempt int, res *http.Response) time.Duration {
+	if delay, ok := retryAfter(res); ok {
+		return delay
+	}
+
+	delay := f.initialBackoff << uint(attempt)
+	if delay <= 0 || delay > f.maxBackoff {
+		delay = f.maxBackoff
+	}
+
+	// Add up to 20% of jitter so crawlers on the same host don't retry at the
+	// same time.
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
+
+// isTransientFailure checks whether a request failed because of an error that
+// might not happen again if the request is retried, i.e. a network error (other
+// than a redirection not being followed), a 5xx status code or a 429 status code.
+func isTransientFailure(res *http.Response, err error) bool {
+	if err != nil {
+		return !isEnqueueRedirect(err) && !isBreakerError(err)
+	}
+
+	return res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+}
+
+// retryError adds a message to the error of a failed request. If the request
+// failed because of its response's status code, an error describing it is
+// created.
+func retryError(res *http.Response, err error, msg string) error {
+	if err != nil {
+		return fmt.Errorf("%v (%s)", err, msg)
+	}
+
+	return fmt.Errorf("Website responded with status %s (%s)", res.Status, msg)
+}
+
+// retryAfter returns the time a response's Retry-After header asks to wait for
+// before retrying the request.
+// Returns false if there's no response, or if it doesn't have a valid
+// Retry-After header.
+func retryAfter(res *http.Response) (time.Duration, bool) {
+	if res == nil {
+		return 0, false
+	}
+
+	return parseRetryAfter(res.Header.Get("Retry-After"))
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which can either be
+// a number of seconds or an HTTP date.
+// Returns false if the header is empty or invalid.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if len(value) == 0 {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		delay := date.Sub(time.Now())
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,127 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"common/config"
+)
+
+// statusFetcher is a Fetcher responding to every request with the given status
+// code and headers, and counting the requests.
+type statusFetcher struct {
+	status   int
+	header   http.Header
+	requests int
+}
+
+func (f *statusFetcher) Fetch(u *url.URL, userAgent string, headRequest bool) (*http.Response, error) {
+	f.requests++
+	return &http.Response{
+		StatusCode: f.status,
+		Status:     http.StatusText(f.status),
+		Header:     f.header,
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+	}, nil
+}
+
+func TestRetryFetcherLongRetryAfter(t *testing.T) {
+	fetcher := &statusFetcher{
+		status: http.StatusServiceUnavailable,
+		header: http.Header{"Retry-After": {"3600"}},
+	}
+	f := NewRetryFetcher(&config.RetryConfig{MaxRetries: 3, MaxBackoff: 1}, nil, fetcher)
+
+	// The crawl is aborted rather than blocked for an hour, even though
+	// circuit breakers are disabled.
+	u, _ := url.Parse("http://long-retry-after.tld/")
+	if _, err := f.Fetch(u, "TestAgent", false); !isBreakerError(err) {
+		t.Fatalf("Fetch returned %v, want a breaker error", err)
+	}
+	if fetcher.requests != 1 {
+		t.Errorf("Request was sent %d times, want 1", fetcher.requests)
+	}
+
+	// The next requests to the host fail at once, without being sent.
+	start := time.Now()
+	if _, err := f.Fetch(u, "TestAgent", false); !isBreakerError(err) {
+		t.Errorf("Next fetch returned %v, want a breaker error", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Next fetch returned after %s", elapsed)
+	}
+	if fetcher.requests != 1 {
+		t.Errorf("Request was sent %d times, want 1", fetcher.requests)
+	}
+}
+
+func TestRetryFetcherRetryAfterWithinBreakerPause(t *testing.T) {
+	fetcher := &statusFetcher{
+		status: http.StatusTooManyRequests,
+		header: http.Header{"Retry-After": {"90"}},
+	}
+	f := NewRetryFetcher(
+		&config.RetryConfig{MaxRetries: 3, MaxBackoff: 1},
+		&config.BreakerConfig{Threshold: 5, Pause: 120},
+		fetcher,
+	)
+
+	u, _ := url.Parse("http://breaker-retry-after.tld/")
+	res, err := f.Fetch(u, "TestAgent", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Status code = %d", res.StatusCode)
+	}
+	if fetcher.requests != 1 {
+		t.Errorf("Request was sent %d times, want 1", fetcher.requests)
+	}
+
+	// The host is paused for the requested time, and the failure is counted
+	// by its breaker.
+	b := getBreaker("breaker-retry-after.tld")
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if pause := b.until.Sub(time.Now()); pause < 89*time.Second || pause > 90*time.Second {
+		t.Errorf("Host paused for %s, want 90s", pause)
+	}
+	if b.failures != 1 || b.trips != 0 {
+		t.Errorf("Breaker counted %d failures and %d trips", b.failures, b.trips)
+	}
+}
+
+func TestRetryFetcherShortRetryAfter(t *testing.T) {
+	fetcher := &statusFetcher{
+		status: http.StatusTooManyRequests,
+		header: http.Header{"Retry-After": {"0"}},
+	}
+	f := NewRetryFetcher(&config.RetryConfig{MaxRetries: 2, MaxBackoff: 1}, nil, fetcher)
+
+	u, _ := url.Parse("http://short-retry-after.tld/")
+	if _, err := f.Fetch(u, "TestAgent", false); err != nil {
+		t.Fatal(err)
+	}
+	if fetcher.requests != 3 {
+		t.Errorf("Request was sent %d times, want 3", fetcher.requests)
+	}
+}
@@ -0,0 +1,313 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"container/heap"
+	"net/url"
+	"regexp"
+	"sync"
+
+	"common/config"
+
+	"github.com/PuerkitoBio/gocrawl"
+	"github.com/PuerkitoBio/purell"
+)
+
+// urlNormalizationFlags are the flags gocrawl uses to normalize URLs. The
+// scheduler uses them too, so it only hands gocrawl links it won't ignore.
+const urlNormalizationFlags = purell.FlagsAllGreedy
+
+const (
+	// maxQueuedLinks is the maximum number of links handed to gocrawl at the
+	// same time. gocrawl visits the pages of a host one at a time, so the other
+	// links wait in the scheduler's frontier, where they can still be
+	// reordered.
+	maxQueuedLinks = 2
+
+	// Scores added to, or removed from, the priority of a link.
+	articleLinkScore = 100
+	freshLinkScore   = 50
+	archiveLinkScore = -40
+	depthScore       = -10
+)
+
+var (
+	// articleLinkRegexp matches the paths of URLs that look like the URL of an
+	// article, i.e. that contain a date, end with a long slug, or end with a
+	// numeric identifier. It is only used if the website's configuration
+	// doesn't provide article patterns.
+	articleLinkRegexp = regexp.MustCompile(
+		`/(19|20)\d{2}[/-]\d{1,2}([/-]\d{1,2})?/|/[^/]*\w+-\w+-\w+-\w+[^/]*/?$|[/-]\d{5,}(\.html?)?/?$`,
+	)
+	// archiveLinkRegexp matches the URLs of pages that list articles but are
+	// unlikely to list new ones, i.e. tags, authors and archive pages, and pages
+	// following the first one of a listing.
+	archiveLinkRegexp = regexp.MustCompile(
+		`(?i)/(tags?|authors?|archives?|page)/|[?&](page|p)=\d+`,
+	)
+)
+
+// linkState is the state gocrawl carries along with each link handed to it by
+// the scheduler, which allows identifying the link once gocrawl is done with
+// it, even though its URL can have been modified in the meantime (e.g. by
+// Extender.Filter), and telling whether it is a start point.
+type linkState struct {
+	url   string
+	depth int
+	seed  bool
+}
+
+// linkDepth returns the number of links followed from a start point to reach a
+// page, or 0 if it isn't known.
+func linkDepth(ctx *gocrawl.URLContext) int {
+	if state, ok := ctx.State.(*linkState); ok {
+		return state.depth
+	}
+
+	return 0
+}
+
+// frontierLink is a link waiting in the scheduler's frontier.
+type frontierLink struct {
+	url      string
+	depth    int
+	priority int
+	// Order in which the link was discovered, used to visit links with the
+	// same priority in that order.
+	seq int
+	// seed is true if the link is a start point (see scheduler.seed).
+	seed bool
+}
+
+// frontier is a priority queue of links, implementing heap.Interface.
+type frontier []*frontierLink
+
+func (f frontier) Len() int { return len(f) }
+
+func (f frontier) Less(i, j int) bool {
+	if f[i].priority != f[j].priority {
+		return f[i].priority > f[j].priority
+	}
+	return f[i].seq < f[j].seq
+}
+
+func (f frontier) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
+
+func (f *frontier) Push(x interface{}) { *f = append(*f, x.(*frontierLink)) }
+
+func (f *frontier) Pop() interface{} {
+	old := *f
+	link := old[len(old)-1]
+	*f = old[:len(old)-1]
+	return link
+}
+
+// scheduler decides in which order the links found on a website are visited.
+// Instead of letting gocrawl enqueue every link it finds, links are kept in a
+// frontier, ordered by priority (see linkPriority), and handed to gocrawl a few
+// at a time, so that, when the number of visits is limited, the budget is spent
+// on the pages the most likely to be new articles. It is safe to use from
+// several goroutines.
+type scheduler struct {
+	lock     sync.Mutex
+	frontier frontier
+	maxDepth int
+	// articles contains the website's article patterns, if any.
+	articles config.Patterns
+	// seen contains the URLs of all the links added to the frontier, so each
+	// link is only visited once.
+	seen map[string]bool
+	// queued contains the URLs of the links handed to gocrawl which it isn't
+	// done with yet.
+	queued map[string]bool
+	// hosts contains the normalized hosts of the start points, which are the
+	// only ones gocrawl visits pages on.
+	hosts map[string]bool
+	seq   int
+	// stopped is true if no link should be visited anymore (see stop).
+	stopped bool
+}
+
+// newScheduler instantiates a new scheduler, which ignores links deeper than a
+// given depth, unless it is 0, and favours the links matching the given article
+// patterns, if any.
+func newScheduler(maxDepth int, articles config.Patterns) *scheduler {
+	return &scheduler{
+		maxDepth: maxDepth,
+		articles: articles,
+		seen:     make(map[string]bool),
+		queued:   make(map[string]bool),
+		hosts:    make(map[string]bool),
+	}
+}
+
+// seed returns the given start points in a form that can be handed to gocrawl,
+// marking them as handed to it. If there are more start points than links
+// gocrawl can be handed at once (e.g. the archive pages of a backfill), the
+// other ones are added to the frontier, in the given order.
+func (s *scheduler) seed(urls []string) gocrawl.S {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	seeds := make(gocrawl.S)
+	for _, u := range urls {
+		if s.seen[u] {
+			continue
+		}
+		s.seen[u] = true
+		if host, ok := normalizedHost(u); ok {
+			s.hosts[host] = true
+		}
+
+		if len(seeds) < maxQueuedLinks {
+			s.queued[u] = true
+			seeds[u] = &linkState{url: u, seed: true}
+			continue
+		}
+
+		s.seq++
+		heap.Push(&s.frontier, &frontierLink{url: u, seq: s.seq, seed: true})
+	}
+
+	return seeds
+}
+
+// add adds a link to the frontier, unless it was already added, it is deeper
+// than the maximum depth, or it leads to another host than the start points'
+// ones (gocrawl would ignore it without notice). A link is fresh if it appeared
+// on the page it was found on since the page's last visit.
+func (s *scheduler) add(u *url.URL, depth int, fresh bool) {
+	if s.maxDepth > 0 && depth > s.maxDepth {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.stopped || s.seen[u.String()] {
+		return
+	}
+	if host, ok := normalizedHost(u.String()); !ok || !s.hosts[host] {
+		return
+	}
+	s.seen[u.String()] = true
+
+	s.seq++
+	heap.Push(&s.frontier, &frontierLink{
+		url:      u.String(),
+		depth:    depth,
+		priority: s.linkPriority(u, depth, fresh),
+		seq:      s.seq,
+	})
+}
+
+// next removes the link with the highest priority from the frontier and marks
+// it as handed to gocrawl, unless the frontier is empty or too many links are
+// already handed to gocrawl, in which case nil is returned.
+func (s *scheduler) next() *frontierLink {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.frontier) == 0 || len(s.queued) >= maxQueuedLinks {
+		return nil
+	}
+
+	link := heap.Pop(&s.frontier).(*frontierLink)
+	s.queued[link.url] = true
+
+	return link
+}
+
+// stop empties the frontier and ignores the links added from now on, so the
+// crawl ends once gocrawl is done with the links already handed to it.
+func (s *scheduler) stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.stopped = true
+	s.frontier = nil
+}
+
+// putBack puts a link returned by next back in the frontier, in case it
+// couldn't be handed to gocrawl.
+func (s *scheduler) putBack(link *frontierLink) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.queued, link.url)
+	heap.Push(&s.frontier, link)
+}
+
+// done marks a link handed to gocrawl as done, either because it was visited,
+// or because it won't be.
+func (s *scheduler) done(ctx *gocrawl.URLContext) {
+	state, ok := ctx.State.(*linkState)
+	if !ok {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.queued, state.url)
+}
+
+// normalizedHost returns the host of a URL once normalized the same way gocrawl
+// normalizes URLs, and false if the URL is invalid.
+func normalizedHost(rawURL string) (string, bool) {
+	normalized, err := purell.NormalizeURLString(rawURL, urlNormalizationFlags)
+	if err != nil {
+		return "", false
+	}
+
+	u, err := url.Parse(normalized)
+	if err != nil {
+		return "", false
+	}
+
+	return u.Host, true
+}
+
+// linkPriority computes the priority of a link, favouring links to articles
+// (i.e. matching the website's article patterns, or looking like the URL of an
+// article if there are none), links that appeared on their page since its last
+// visit, and links closer to the start point, over the others, especially the
+// ones to archive pages.
+func (s *scheduler) linkPriority(u *url.URL, depth int, fresh bool) (priority int) {
+	if s.isArticleLink(u) {
+		priority += articleLinkScore
+	}
+	if archiveLinkRegexp.MatchString(u.RequestURI()) {
+		priority += archiveLinkScore
+	}
+	if fresh {
+		priority += freshLinkScore
+	}
+
+	return priority + depth*depthScore
+}
+
+// isArticleLink checks whether a link looks like the URL of an article, i.e.
+// whether it matches the website's article patterns if it has any, or else
+// whether its path looks like the ones of articles.
+func (s *scheduler) isArticleLink(u *url.URL) bool {
+	if s.articles != nil {
+		return s.articles.MatchString(u.String())
+	}
+
+	return articleLinkRegexp.MatchString(u.Path)
+}
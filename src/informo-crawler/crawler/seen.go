@@ -0,0 +1,121 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"common"
+
+	"github.com/PuerkitoBio/gocrawl"
+	"github.com/sirupsen/logrus"
+)
+
+// loadRecentlySeen loads the URLs of the pages of the extender's website that
+// don't need to be visited again yet, i.e. the pages that are neither articles
+// nor listing pages and were visited within the website's recheck interval.
+// Nothing is loaded if the website doesn't have a recheck interval.
+// Returns an error if the URLs couldn't be retrieved from the database.
+func (e *Extender) loadRecentlySeen() (err error) {
+	if e.website.RecheckInterval <= 0 {
+		return
+	}
+
+	since := time.Now().Add(-e.website.RecheckInterval * time.Second)
+	if e.recentlySeen, err = e.db.RetrieveSeenURLsForWebsiteSince(e.website.Identifier, since); err != nil {
+		return
+	}
+
+	e.log.Infof("Loaded %d recently seen URLs for this website", len(e.recentlySeen))
+
+	return
+}
+
+// isRecentlySeen checks whether the page at the given URL, handed to gocrawl
+// with the given state, was visited within the website's recheck interval, and
+// is neither an article nor a listing page. Start points (see scheduler.seed)
+// are never considered as recently seen, since the run would otherwise visit
+// nothing, e.g. if the website failed to respond to its start point once.
+func (e *Extender) isRecentlySeen(u *url.URL, state interface{}) bool {
+	if s, ok := state.(*linkState); ok && s.seed {
+		return false
+	}
+
+	return e.recentlySeen[u.String()]
+}
+
+// saveSeenURL saves the fetch of a page that isn't an article, so it can be
+// skipped until the website's recheck interval expires if it isn't a listing
+// page either. Pages are classified as listing pages if they match the
+// website's listing patterns, or if they link to at least one article. Nothing
+// is saved if the website doesn't have a recheck interval, nor for the pages
+// expected to be articles (see isArticlePage) from which no article could be
+// extracted, since they need to be parsed again during the next runs, unless
+// the website responded with an error.
+func (e *Extender) saveSeenURL(u *url.URL, statusCode int, links []string) {
+	if e.website.RecheckInterval <= 0 {
+		return
+	}
+	if statusCode < 400 && e.isArticlePage(u) {
+		return
+	}
+
+	classification := common.PageOther
+	if statusCode >= 400 {
+		classification = common.PageError
+	} else if e.website.Filters != nil && e.website.Filters.Listing.MatchString(u.String()) {
+		classification = common.PageListing
+	} else {
+		for _, link := range links {
+			if l, err := url.Parse(link); err == nil && e.scheduler.isArticleLink(l) {
+				classification = common.PageListing
+				break
+			}
+		}
+	}
+
+	err := e.db.SaveSeenURL(e.website.Identifier, &common.SeenURL{
+		URL:            u.String(),
+		Classification: classification,
+		StatusCode:     statusCode,
+		FetchedAt:      time.Now(),
+	})
+	if err != nil {
+		e.errChan <- &loggedError{
+			fmt.Errorf("Couldn't save the fetch of a page: %v", err),
+			logrus.Fields{"url": u.String()},
+		}
+	}
+}
+
+// isClientError checks whether a response's status code is a client error that
+// won't go away by requesting the page again soon, e.g. 404 or 410, unlike 429.
+func isClientError(res *http.Response) bool {
+	return res.StatusCode >= 400 && res.StatusCode < 500 &&
+		res.StatusCode != http.StatusTooManyRequests
+}
+
+// saveFetchError saves the fetch of a page the website responded to with a
+// client error, so it isn't requested again until the website's recheck
+// interval expires.
+func (e *Extender) saveFetchError(ctx *gocrawl.URLContext, res *http.Response) {
+	if res != nil && isClientError(res) {
+		e.saveSeenURL(ctx.URL(), res.StatusCode, nil)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/PuerkitoBio/gocrawl"
+)
+
+func TestRecentlySeenStartPointsAreVisited(t *testing.T) {
+	// More start points than links gocrawl can be handed at once, so some of
+	// them go through the frontier.
+	var seeds []string
+	for i := 0; i < maxQueuedLinks+2; i++ {
+		seeds = append(seeds, fmt.Sprintf("http://news.example/archive/%d", i))
+	}
+	link, _ := url.Parse("http://news.example/about")
+
+	enqueued := make(chan interface{}, len(seeds))
+	e := &Extender{
+		DefaultExtender: gocrawl.DefaultExtender{EnqueueChan: enqueued},
+		recentlySeen:    map[string]bool{link.String(): true},
+		scheduler:       newScheduler(0, nil),
+	}
+	for _, seed := range seeds {
+		e.recentlySeen[seed] = true
+	}
+
+	// Collect the states gocrawl is handed the start points with, both
+	// directly and once the first ones are done.
+	states := make(map[string]interface{})
+	for u, state := range e.scheduler.seed(seeds) {
+		states[u] = state
+		e.release(&gocrawl.URLContext{State: state})
+	}
+	close(enqueued)
+	for s := range enqueued {
+		for u, state := range s.(gocrawl.S) {
+			states[u] = state
+			e.scheduler.done(&gocrawl.URLContext{State: state})
+		}
+	}
+	if len(states) != len(seeds) {
+		t.Fatalf("Got %d start points, want %d", len(states), len(seeds))
+	}
+
+	for u, state := range states {
+		parsed, _ := url.Parse(u)
+		if e.isRecentlySeen(parsed, state) {
+			t.Errorf("Start point %s was skipped", u)
+		}
+	}
+
+	e.scheduler.add(link, 1, false)
+	next := e.scheduler.next()
+	if next == nil {
+		t.Fatal("Link wasn't added to the frontier")
+	}
+	state := &linkState{url: next.url, depth: next.depth, seed: next.seed}
+	if !e.isRecentlySeen(link, state) {
+		t.Errorf("Recently seen link %s wasn't skipped", link)
+	}
+}
@@ -0,0 +1,353 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"common/config"
+	"common/database"
+
+	"github.com/PuerkitoBio/gocrawl"
+)
+
+// Session holds the state of the HTTP client used to send requests to a
+// website: the transport settings, the headers sent along with every request,
+// and the cookies set by the website if the website's configuration requires
+// keeping them (see config.SessionConfig).
+type Session struct {
+	cfg     *config.SessionConfig
+	client  *http.Client
+	headers http.Header
+	jar     *cookieJar
+	db      *database.Database
+}
+
+// NewSession instantiates the session to use when sending requests to a given
+// website, according to the crawler's and the website's configuration. If the
+// website's configuration requires it, the cookies saved during the previous
+// run are loaded, and the configured cookies are added to them. If a database
+// is provided, requests for pages that were already visited are sent
+// conditionally, using the validators saved in it (see setValidators).
+// Returns an error if the transport settings are invalid, if an environment
+// variable referenced in the configuration isn't set, or if the cookies
+// couldn't be loaded.
+func NewSession(
+	cfg config.CrawlerConfig, website *config.Website, db *database.Database,
+) (s *Session, err error) {
+	s = &Session{cfg: website.Session, headers: make(http.Header), db: db}
+
+	for name, value := range website.Headers {
+		if value, err = expandEnv(value); err != nil {
+			return nil, fmt.Errorf("Invalid %s header: %v", name, err)
+		}
+		s.headers.Set(name, value)
+	}
+
+	// Use the website's transport settings, falling back to the ones shared
+	// by all websites.
+	if s.client, err = newHTTPClient(cfg.Transport.Merge(website.Transport)); err != nil {
+		return nil, err
+	}
+
+	if s.cfg == nil {
+		return
+	}
+
+	if s.jar, err = newCookieJar(s.cfg.CookieFile); err != nil {
+		return nil, fmt.Errorf("Couldn't load cookies: %v", err)
+	}
+	if err = s.addCookies(website.StartPoint); err != nil {
+		return nil, err
+	}
+
+	// Copy the client before setting its cookie jar, since it can be gocrawl's
+	// default one, which is shared by all websites.
+	client := *gocrawl.HttpClient
+	if s.client != nil {
+		client = *s.client
+	}
+	client.Jar = s.jar
+	s.client = &client
+
+	return
+}
+
+// addCookies adds the cookies from the session's configuration to the cookie
+// jar, for the host of the given URL.
+// Returns an error if the URL couldn't be parsed, or if an environment variable
+// referenced in a cookie's value isn't set.
+func (s *Session) addCookies(rawURL string) error {
+	if len(s.cfg.Cookies) == 0 {
+		return nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	cookies := make([]*http.Cookie, 0, len(s.cfg.Cookies))
+	for name, value := range s.cfg.Cookies {
+		if value, err = expandEnv(value); err != nil {
+			return fmt.Errorf("Invalid %s cookie: %v", name, err)
+		}
+		cookies = append(cookies, &http.Cookie{Name: name, Value: value, Path: "/"})
+	}
+	s.jar.SetCookies(u, cookies)
+
+	return nil
+}
+
+// Login submits the login or consent form from the session's configuration,
+// if any, using the given user agent. Unlike the requests sent while crawling,
+// redirects are followed, since submitting a form usually redirects to another
+// page, which can set cookies as well. The form isn't submitted if the session
+// already has the cookie the form is expected to set.
+// Returns an error if an environment variable referenced in the form's fields
+// isn't set, if the form couldn't be submitted, if the website responded with
+// a 4xx or 5xx status code, or if the expected cookie wasn't set.
+func (s *Session) Login(userAgent string) (err error) {
+	if s == nil || s.cfg == nil || s.cfg.Login == nil {
+		return
+	}
+	login := s.cfg.Login
+
+	u, err := url.Parse(login.URL)
+	if err != nil {
+		return
+	}
+	if len(login.Cookie) > 0 && s.hasCookie(u, login.Cookie) {
+		return
+	}
+
+	form := make(url.Values)
+	for name, value := range login.Fields {
+		if value, err = expandEnv(value); err != nil {
+			return fmt.Errorf("Invalid %s field: %v", name, err)
+		}
+		form.Set(name, value)
+	}
+
+	var req *http.Request
+	if strings.ToUpper(login.Method) == "GET" {
+		u.RawQuery = form.Encode()
+		req, err = http.NewRequest("GET", u.String(), nil)
+	} else {
+		req, err = http.NewRequest("POST", u.String(), strings.NewReader(form.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if err != nil {
+		return
+	}
+	s.setHeaders(req, userAgent)
+
+	client := *s.client
+	client.CheckRedirect = nil
+	res, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("Website responded with status %s", res.Status)
+	}
+	if len(login.Cookie) > 0 && !s.hasCookie(u, login.Cookie) {
+		return fmt.Errorf("Website didn't set the %s cookie", login.Cookie)
+	}
+
+	return
+}
+
+// Save saves the session's cookies to the cookie file from the session's
+// configuration, if any, so they can be reused during the next run.
+// Returns an error if the cookies couldn't be written.
+func (s *Session) Save() error {
+	if s == nil || s.jar == nil {
+		return nil
+	}
+
+	return s.jar.save()
+}
+
+// hasCookie checks whether the session has a cookie with the given name for
+// the given URL.
+func (s *Session) hasCookie(u *url.URL, name string) bool {
+	for _, cookie := range s.jar.Cookies(u) {
+		if cookie.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setHeaders sets the session's headers on a request, along with the given user
+// agent.
+func (s *Session) setHeaders(req *http.Request, userAgent string) {
+	for name, values := range s.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("User-Agent", userAgent)
+}
+
+// expandEnv replaces the references to environment variables (e.g. $FOO or
+// ${FOO}) in the given string with their values.
+// Returns an error if a referenced environment variable isn't set, so that
+// credentials aren't silently sent empty.
+func expandEnv(s string) (expanded string, err error) {
+	expanded = os.Expand(s, func(name string) string {
+		value, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s isn't set", name)
+		}
+		return value
+	})
+
+	return
+}
+
+// savedCookie is a cookie saved in a cookie file, along with the URL it was set
+// for.
+type savedCookie struct {
+	URL    string       `json:"url"`
+	Cookie *http.Cookie `json:"cookie"`
+}
+
+// cookieJar is a cookie jar which keeps track of the cookies it stores, so they
+// can be saved to a file and loaded during the next run. The standard library's
+// cookie jar doesn't allow listing its cookies. It is safe to use from several
+// goroutines.
+type cookieJar struct {
+	*cookiejar.Jar
+	path    string
+	lock    sync.Mutex
+	cookies map[string]savedCookie
+}
+
+// newCookieJar instantiates a new cookie jar saving its cookies to the given
+// file, and loads the cookies already saved in it, if it exists. If no path is
+// provided, the cookies are only kept in memory.
+// Returns an error if the file couldn't be read or decoded.
+func newCookieJar(path string) (j *cookieJar, err error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return
+	}
+	j = &cookieJar{Jar: jar, path: path, cookies: make(map[string]savedCookie)}
+
+	if len(path) == 0 {
+		return
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var saved []savedCookie
+	if err = json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+	for _, c := range saved {
+		u, err := url.Parse(c.URL)
+		if err != nil || c.Cookie == nil {
+			continue
+		}
+		j.SetCookies(u, []*http.Cookie{c.Cookie})
+	}
+
+	return j, nil
+}
+
+// SetCookies implements http.CookieJar.SetCookies
+// Keeps track of the cookies in addition to storing them in the jar. The
+// cookies' Max-Age attribute is converted to an expiry time, so it still
+// makes sense once the cookies are loaded again.
+func (j *cookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.Jar.SetCookies(u, cookies)
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	now := time.Now()
+	for _, cookie := range cookies {
+		c := *cookie
+		if c.MaxAge > 0 {
+			c.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+			c.MaxAge = 0
+		}
+
+		domain := c.Domain
+		if len(domain) == 0 {
+			domain = u.Hostname()
+		}
+		key := domain + ";" + c.Path + ";" + c.Name
+
+		if c.MaxAge < 0 || (!c.Expires.IsZero() && c.Expires.Before(now)) {
+			delete(j.cookies, key)
+		} else {
+			j.cookies[key] = savedCookie{URL: u.String(), Cookie: &c}
+		}
+	}
+}
+
+// save writes the jar's cookies to its file, if it has one. The file is first
+// written under a temporary name, so an interrupted write doesn't lose the
+// cookies saved by the previous run.
+// Returns an error if the file couldn't be written.
+func (j *cookieJar) save() (err error) {
+	if len(j.path) == 0 {
+		return
+	}
+
+	j.lock.Lock()
+	saved := make([]savedCookie, 0, len(j.cookies))
+	for _, c := range j.cookies {
+		saved = append(saved, c)
+	}
+	j.lock.Unlock()
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return
+	}
+
+	if err = os.MkdirAll(filepath.Dir(j.path), 0700); err != nil {
+		return
+	}
+	tmp := j.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return
+	}
+
+	return os.Rename(tmp, j.path)
+}
@@ -0,0 +1,157 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"common/database"
+)
+
+const (
+	// Number of consecutive words hashed together when fingerprinting a text.
+	shingleSize = 3
+	// Minimum number of words a text needs to contain to be fingerprinted.
+	// Shorter texts (e.g. captions, or the first lines of subscriber content)
+	// are too similar to each other for fingerprints to mean anything.
+	minFingerprintWords = 50
+	// Maximum number of bits that can differ between the fingerprints of two
+	// articles for one to be considered as a near-duplicate of the other.
+	maxDuplicateDistance = 3
+	// Period of time before now the articles are compared with when looking
+	// for near-duplicates. Wire stories are usually published by all websites
+	// within a few hours.
+	duplicateWindow = 7 * 24 * time.Hour
+)
+
+// fingerprint computes the 64-bit SimHash fingerprint of a text, from the
+// hashes of its lowercased words grouped in shingles, so that the fingerprints
+// of texts differing by a few words only differ by a few bits.
+// Returns nil if the text is too short to be fingerprinted.
+func fingerprint(text string) *uint64 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) < minFingerprintWords {
+		return nil
+	}
+
+	var weights [64]int
+	h := fnv.New64a()
+	for i := 0; i+shingleSize <= len(words); i++ {
+		h.Reset()
+		h.Write([]byte(strings.Join(words[i:i+shingleSize], " ")))
+		sum := h.Sum64()
+		for bit := uint(0); bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var fp uint64
+	for bit := uint(0); bit < 64; bit++ {
+		if weights[bit] > 0 {
+			fp |= 1 << bit
+		}
+	}
+
+	return &fp
+}
+
+// fingerprintEntry is an article's fingerprint in a fingerprintIndex.
+type fingerprintEntry struct {
+	url         string
+	fingerprint uint64
+	// URL of the first saved article of the entry's group of near-duplicates,
+	// which is the article's URL if it isn't a near-duplicate.
+	original string
+}
+
+// fingerprintIndex contains the fingerprints of the articles saved recently
+// on all websites. It is shared by all crawlers, so articles can be matched
+// with near-duplicates from other websites during the same run.
+type fingerprintIndex struct {
+	loadOnce sync.Once
+	loadErr  error
+	lock     sync.Mutex
+	entries  []fingerprintEntry
+}
+
+// duplicates is the index shared by all crawlers.
+var duplicates = &fingerprintIndex{}
+
+// load fills the index with the fingerprints of the articles saved within the
+// duplicate window. Only the first call loads them, subsequent calls return
+// the result of the first one.
+// Returns an error if the fingerprints couldn't be retrieved.
+func (i *fingerprintIndex) load(db *database.Database) error {
+	i.loadOnce.Do(func() {
+		fps, err := db.RetrieveArticlesFingerprintsSince(time.Now().Add(-duplicateWindow))
+		if err != nil {
+			i.loadErr = err
+			return
+		}
+
+		i.lock.Lock()
+		defer i.lock.Unlock()
+		for _, fp := range fps {
+			original := fp.Original
+			if len(original) == 0 {
+				original = fp.URL
+			}
+			i.entries = append(i.entries, fingerprintEntry{fp.URL, fp.Fingerprint, original})
+		}
+	})
+
+	return i.loadErr
+}
+
+// match looks for the closest near-duplicate of an article in the index, then
+// adds the article to it. Doing both at once ensures two near-duplicates saved
+// at the same time by two crawlers can't be missed.
+// Returns the URL of the original article of the group of near-duplicates the
+// article belongs to and the distance to its closest near-duplicate, or an
+// empty string if it isn't a near-duplicate of any indexed article.
+func (i *fingerprintIndex) match(url string, fp uint64) (original string, distance int) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	distance = maxDuplicateDistance + 1
+	for _, entry := range i.entries {
+		if entry.url == url {
+			continue
+		}
+		if d := bits.OnesCount64(entry.fingerprint ^ fp); d < distance {
+			original, distance = entry.original, d
+		}
+	}
+
+	if len(original) == 0 {
+		i.entries = append(i.entries, fingerprintEntry{url, fp, url})
+	} else {
+		i.entries = append(i.entries, fingerprintEntry{url, fp, original})
+	}
+
+	return
+}
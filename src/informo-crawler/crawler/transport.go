@@ -0,0 +1,135 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"common/config"
+
+	"github.com/PuerkitoBio/gocrawl"
+)
+
+const (
+	// defaultConnectTimeout is the maximum time establishing a connection can
+	// take if no connect timeout is configured.
+	defaultConnectTimeout = 30 * time.Second
+	// tlsHandshakeTimeout is the maximum time a TLS handshake can take.
+	tlsHandshakeTimeout = 10 * time.Second
+)
+
+// newHTTPClient instantiates the HTTP client to use when sending requests to a
+// website, according to the given transport configuration. If no configuration
+// is provided, nil is returned, meaning gocrawl's default HTTP client should be
+// used. Redirects are handled the same way as with gocrawl's default client,
+// i.e. they're not followed but enqueued.
+// Returns an error if the proxy URL is invalid, or if the CA bundle or the
+// client certificate couldn't be loaded.
+func newHTTPClient(cfg *config.TransportConfig) (client *http.Client, err error) {
+	if cfg == nil {
+		return
+	}
+
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return
+	}
+
+	connectTimeout := cfg.ConnectTimeout * time.Second
+	if connectTimeout == 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   connectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: tlsHandshakeTimeout,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+	}
+
+	// SOCKS5 proxies are supported by net/http, which passes host names to
+	// the proxy unresolved, making it suitable for Tor.
+	if len(cfg.Proxy) > 0 {
+		var proxyURL *url.URL
+		if proxyURL, err = url.Parse(cfg.Proxy); err != nil {
+			err = fmt.Errorf("Invalid proxy URL: %v", err)
+			return
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	client = &http.Client{
+		Transport:     transport,
+		CheckRedirect: gocrawl.HttpClient.CheckRedirect,
+		Timeout:       cfg.Timeout * time.Second,
+	}
+
+	return
+}
+
+// newTLSConfig instantiates the TLS configuration matching the given transport
+// configuration. Returns nil if the default TLS configuration can be used.
+// Returns an error if the CA bundle or the client certificate couldn't be
+// loaded.
+func newTLSConfig(cfg *config.TransportConfig) (tlsConfig *tls.Config, err error) {
+	if len(cfg.CABundle) == 0 && len(cfg.ClientCert) == 0 && !cfg.InsecureSkipVerify {
+		return
+	}
+
+	tlsConfig = &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+
+	if len(cfg.CABundle) > 0 {
+		var pem []byte
+		if pem, err = ioutil.ReadFile(cfg.CABundle); err != nil {
+			err = fmt.Errorf("Couldn't read CA bundle: %v", err)
+			return
+		}
+
+		// Trust the bundle's certificates in addition to the system's ones.
+		pool, poolErr := x509.SystemCertPool()
+		if poolErr != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		tlsConfig.RootCAs = pool
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			err = fmt.Errorf("No certificate found in CA bundle %s", cfg.CABundle)
+			return
+		}
+	}
+
+	if len(cfg.ClientCert) > 0 {
+		var cert tls.Certificate
+		if cert, err = tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey); err != nil {
+			err = fmt.Errorf("Couldn't load client certificate: %v", err)
+			return
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return
+}
@@ -0,0 +1,227 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"common/config"
+)
+
+// writePEM writes a PEM block of the given type to a file in the given
+// directory, and returns the file's path.
+func writePEM(t *testing.T, dir string, name string, blockType string, data []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// tempDir creates a temporary directory, and returns its path along with a
+// function removing it.
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "informo-transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// get sends a GET request to the given URL with the given client.
+// Returns the response's body, or an error if the request failed.
+func get(client *http.Client, u string) (string, error) {
+	res, err := client.Get(u)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	return string(body), err
+}
+
+func TestNewHTTPClientWithoutConfig(t *testing.T) {
+	client, err := newHTTPClient(nil)
+	if err != nil || client != nil {
+		t.Errorf("newHTTPClient(nil) = %v, %v, want the default client", client, err)
+	}
+}
+
+func TestNewHTTPClientCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("secure"))
+	}))
+	defer server.Close()
+
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	// Without the CA bundle, the server's certificate isn't trusted.
+	client, err := newHTTPClient(&config.TransportConfig{Timeout: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = get(client, server.URL); err == nil {
+		t.Error("Untrusted certificate was accepted")
+	}
+
+	bundle := writePEM(t, dir, "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+	if client, err = newHTTPClient(&config.TransportConfig{CABundle: bundle, Timeout: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if body, err := get(client, server.URL); err != nil || body != "secure" {
+		t.Errorf("Request with CA bundle = %q, %v", body, err)
+	}
+
+	// Invalid bundles are rejected.
+	if _, err = newHTTPClient(&config.TransportConfig{CABundle: filepath.Join(dir, "missing.pem")}); err == nil {
+		t.Error("Missing CA bundle was accepted")
+	}
+	empty := filepath.Join(dir, "empty.pem")
+	if err = ioutil.WriteFile(empty, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = newHTTPClient(&config.TransportConfig{CABundle: empty}); err == nil {
+		t.Error("CA bundle without certificates was accepted")
+	}
+}
+
+func TestNewHTTPClientClientCert(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	// Generate a self-signed client certificate.
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "informo-crawler"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := writePEM(t, dir, "client.pem", "CERTIFICATE", der)
+	keyFile := writePEM(t, dir, "client.key", "EC PRIVATE KEY", keyDER)
+
+	// The server only accepts clients presenting this certificate.
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+	bundle := writePEM(t, dir, "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+
+	client, err := newHTTPClient(&config.TransportConfig{CABundle: bundle, Timeout: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = get(client, server.URL); err == nil {
+		t.Error("Request without client certificate succeeded")
+	}
+
+	client, err = newHTTPClient(&config.TransportConfig{
+		CABundle: bundle, ClientCert: certFile, ClientKey: keyFile, Timeout: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err := get(client, server.URL); err != nil || body != "informo-crawler" {
+		t.Errorf("Request with client certificate = %q, %v", body, err)
+	}
+}
+
+func TestNewHTTPClientProxy(t *testing.T) {
+	// The proxy answers every request itself, telling which URL it was asked
+	// for.
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("proxied " + req.URL.String()))
+	}))
+	defer proxy.Close()
+
+	client, err := newHTTPClient(&config.TransportConfig{Proxy: proxy.URL, Timeout: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The website's host doesn't need to be resolvable, since the request is
+	// sent to the proxy.
+	body, err := get(client, "http://news.invalid/article")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "proxied http://news.invalid/article" {
+		t.Errorf("Request wasn't sent through the proxy: %q", body)
+	}
+}
+
+func TestNewHTTPClientTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	client, err := newHTTPClient(&config.TransportConfig{Timeout: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err = get(client, server.URL); err == nil {
+		t.Error("Request didn't time out")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Request timed out after %s", elapsed)
+	}
+}
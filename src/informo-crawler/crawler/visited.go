@@ -0,0 +1,392 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"encoding/gob"
+	"hash/fnv"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"common/config"
+	"common/database"
+
+	"github.com/PuerkitoBio/purell"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Default rate of URLs a Bloom filter matches without them being the URL of
+	// a saved article.
+	defaultFalsePositiveRate = 0.001
+	// Minimum number of URLs the first slice of a Bloom filter can hold.
+	minBloomCapacity = 1024
+	// Ratio between the false positive rates of two consecutive slices of a
+	// scalable Bloom filter, which keeps the rate of the whole filter below
+	// its target whatever its number of slices.
+	bloomTighteningRatio = 0.5
+	// Ratio between the capacities of two consecutive slices of a scalable
+	// Bloom filter.
+	bloomGrowthRatio = 2
+)
+
+// visitedSet is a set of the articles already saved from a website, identified
+// by their key (see articleKey).
+type visitedSet interface {
+	// has checks whether the article at the given URL is in the set, whatever
+	// the variant of its URL.
+	has(u string) bool
+	// add adds the article at the given URL to the set.
+	add(u string)
+	// close releases the set's resources once the crawl is over, and saves it
+	// if it needs to be saved.
+	close() error
+}
+
+// newVisitedSet instantiates the set of the articles already saved from a
+// website, using the given configuration, which can be nil.
+// Returns an error if the saved articles couldn't be loaded from the database.
+func newVisitedSet(
+	cfg *config.VisitedSetConfig, db *database.Database, website string, log *logrus.Entry,
+) (visitedSet, error) {
+	if cfg != nil && cfg.Type == "bloom" {
+		return newBloomVisitedSet(cfg, db, website, log)
+	}
+
+	visited, err := db.RetrieveArticleURLsForWebsite(website)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Loaded %d visited URLs for this website", len(visited))
+
+	return newArticleSet(visited), nil
+}
+
+// close implements visitedSet.close. The set is only kept in memory, so there's
+// nothing to do.
+func (s *articleSet) close() error {
+	return nil
+}
+
+// bloomVisitedSet is a visitedSet storing the keys of the saved articles in a
+// scalable Bloom filter, so its memory usage doesn't depend on the number of
+// articles saved from the website as much as a map's. Since Bloom filters can
+// match keys that weren't added to them, URLs matching the filter are checked
+// against the database. It is safe to use from several goroutines.
+type bloomVisitedSet struct {
+	lock   sync.Mutex
+	filter *bloomFilter
+	path   string
+	db     *database.Database
+	log    *logrus.Entry
+}
+
+// newBloomVisitedSet loads the Bloom filter of a website from the configured
+// directory. If the website doesn't have one yet, or if articles were saved
+// without being added to it (e.g. if a crawl was interrupted before it could
+// be saved) or it was built with another false positive rate, it is rebuilt
+// from the URLs of the articles saved in the database.
+// Returns an error if the articles couldn't be retrieved from the database.
+func newBloomVisitedSet(
+	cfg *config.VisitedSetConfig, db *database.Database, website string, log *logrus.Entry,
+) (*bloomVisitedSet, error) {
+	rate := cfg.FalsePositiveRate
+	if rate == 0 {
+		rate = defaultFalsePositiveRate
+	}
+
+	s := &bloomVisitedSet{
+		path: filepath.Join(cfg.Dir, website+".bloom"),
+		db:   db,
+		log:  log,
+	}
+
+	count, err := db.CountArticlesForWebsite(website)
+	if err != nil {
+		return nil, err
+	}
+
+	// A filter that can't be read is rebuilt rather than failing the crawl,
+	// since all of its content can be found in the database.
+	if s.filter, err = readBloomFilter(s.path); err != nil {
+		log.Warnf("Couldn't read the Bloom filter of visited URLs: %v", err)
+	}
+	if s.filter != nil && s.filter.Items == count && s.filter.Rate == rate {
+		log.Infof("Loaded the Bloom filter of %d visited URLs for this website", count)
+		return s, nil
+	}
+
+	// Size the filter so the articles already saved fit in its first slice.
+	s.filter = newBloomFilter(count+count/4, rate)
+	err = db.ForEachArticleURLForWebsite(website, func(u string) {
+		s.filter.add(articleKey(u))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Built the Bloom filter of %d visited URLs for this website", count)
+
+	return s, nil
+}
+
+// has implements visitedSet.has. If the URL matches the filter, the database is
+// checked for an article saved under one of the URL's variants. If it can't be
+// checked, the error is logged and the article is considered as not saved.
+func (s *bloomVisitedSet) has(u string) bool {
+	s.lock.Lock()
+	matches := s.filter.has(articleKey(u))
+	s.lock.Unlock()
+
+	if !matches {
+		return false
+	}
+
+	for _, variant := range articleURLVariants(u) {
+		exists, err := s.db.HasArticle(variant)
+		if err != nil {
+			s.log.WithField("url", u).Errorf("Couldn't look for the article in the database: %v", err)
+			return false
+		}
+		if exists {
+			return true
+		}
+	}
+
+	return false
+}
+
+// add implements visitedSet.add.
+func (s *bloomVisitedSet) add(u string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.filter.add(articleKey(u))
+}
+
+// close implements visitedSet.close by saving the filter, so it doesn't need to
+// be rebuilt during the next run.
+// Returns an error if the filter couldn't be written.
+func (s *bloomVisitedSet) close() (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err = os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return
+	}
+
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return
+	}
+	if err = gob.NewEncoder(f).Encode(s.filter); err != nil {
+		f.Close()
+		return
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+// articleURLVariants returns the URLs an article reached through a given URL
+// could have been saved under, i.e. the URL itself, and its canonical form (see
+// CanonicalURL) using HTTP and HTTPS, with and without the "www." prefix.
+func articleURLVariants(rawURL string) []string {
+	variants := []string{rawURL}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return variants
+	}
+
+	seen := map[string]bool{rawURL: true}
+	for _, scheme := range []string{"https", "http"} {
+		for _, www := range []bool{true, false} {
+			v := nonAMPURL(u)
+			v.Scheme = scheme
+			v.Host = trimWWW(v.Host)
+			if www {
+				v.Host = "www." + v.Host
+			}
+
+			variant := purell.NormalizeURL(v, canonicalURLFlags)
+			if !seen[variant] {
+				seen[variant] = true
+				variants = append(variants, variant)
+			}
+		}
+	}
+
+	return variants
+}
+
+// trimWWW removes the "www." prefix from a host, if it has one.
+func trimWWW(host string) string {
+	if len(host) > 4 && host[:4] == "www." {
+		return host[4:]
+	}
+
+	return host
+}
+
+// bloomFilter is a scalable Bloom filter, i.e. a series of Bloom filters (or
+// slices) of growing capacity and decreasing false positive rate: once a slice
+// is full, a new one is added, so the filter can hold any number of items
+// while keeping its false positive rate below the given one.
+// Its fields are exported so it can be saved with encoding/gob. It isn't safe
+// to use from several goroutines.
+type bloomFilter struct {
+	// Target false positive rate of the filter.
+	Rate float64
+	// Number of items added to the filter.
+	Items int
+	// Slices of the filter, from the oldest to the most recent one.
+	Slices []*bloomSlice
+}
+
+// bloomSlice is one of the slices of a bloomFilter, which is a regular Bloom
+// filter.
+type bloomSlice struct {
+	Bits     []uint64
+	Hashes   int
+	Capacity int
+	Items    int
+}
+
+// newBloomFilter instantiates a bloomFilter with a given target false positive
+// rate, and which first slice can hold a given number of items.
+func newBloomFilter(capacity int, rate float64) *bloomFilter {
+	if capacity < minBloomCapacity {
+		capacity = minBloomCapacity
+	}
+
+	f := &bloomFilter{Rate: rate}
+	f.Slices = append(f.Slices, newBloomSlice(capacity, f.sliceRate(0)))
+
+	return f
+}
+
+// sliceRate returns the false positive rate of the i-th slice of the filter,
+// chosen so that the sum of the rates of all slices stays below the filter's.
+func (f *bloomFilter) sliceRate(i int) float64 {
+	return f.Rate * (1 - bloomTighteningRatio) * math.Pow(bloomTighteningRatio, float64(i))
+}
+
+// has checks whether an item was added to the filter. It can return true for
+// items that weren't, at the filter's false positive rate.
+func (f *bloomFilter) has(item string) bool {
+	h1, h2 := bloomHashes(item)
+	for _, s := range f.Slices {
+		if s.has(h1, h2) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// add adds an item to the filter, adding a new slice first if the last one is
+// full.
+func (f *bloomFilter) add(item string) {
+	h1, h2 := bloomHashes(item)
+	last := f.Slices[len(f.Slices)-1]
+	if last.Items >= last.Capacity {
+		last = newBloomSlice(last.Capacity*bloomGrowthRatio, f.sliceRate(len(f.Slices)))
+		f.Slices = append(f.Slices, last)
+	}
+
+	last.add(h1, h2)
+	f.Items++
+}
+
+// newBloomSlice instantiates a bloomSlice able to hold a given number of items
+// at a given false positive rate.
+func newBloomSlice(capacity int, rate float64) *bloomSlice {
+	bits := int(math.Ceil(-float64(capacity) * math.Log(rate) / (math.Ln2 * math.Ln2)))
+	hashes := int(math.Ceil(-math.Log2(rate)))
+
+	return &bloomSlice{
+		Bits:     make([]uint64, (bits+63)/64),
+		Hashes:   hashes,
+		Capacity: capacity,
+	}
+}
+
+// has checks whether the bits matching the given hashes of an item are all set
+// in the slice.
+func (s *bloomSlice) has(h1, h2 uint64) bool {
+	size := uint64(len(s.Bits)) * 64
+	for i := 0; i < s.Hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % size
+		if s.Bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// add sets the bits matching the given hashes of an item in the slice.
+func (s *bloomSlice) add(h1, h2 uint64) {
+	size := uint64(len(s.Bits)) * 64
+	for i := 0; i < s.Hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % size
+		s.Bits[bit/64] |= 1 << (bit % 64)
+	}
+	s.Items++
+}
+
+// bloomHashes returns the two hashes of an item the positions of its bits in a
+// Bloom filter's slice are derived from (using double hashing).
+func bloomHashes(item string) (h1, h2 uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	h1 = h.Sum64()
+
+	h = fnv.New64()
+	h.Write([]byte(item))
+	// Make sure the second hash is odd, so the positions don't all end up
+	// being the same.
+	h2 = h.Sum64() | 1
+
+	return
+}
+
+// readBloomFilter reads a Bloom filter from the file at a given path.
+// Returns nil if the file doesn't exist, or an error if it couldn't be read or
+// decoded.
+func readBloomFilter(path string) (*bloomFilter, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	filter := &bloomFilter{}
+	if err = gob.NewDecoder(f).Decode(filter); err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,330 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crawler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"common/config"
+)
+
+// defaultWARCMaxSize is the size, in megabytes, after which a WARC file is
+// rotated if no maximum size is provided in the configuration.
+const defaultWARCMaxSize = 1000
+
+// warcDateFormat is the format of the dates in WARC records' headers.
+const warcDateFormat = "2006-01-02T15:04:05Z"
+
+// WARCFetcher implements Fetcher by fetching resources using another Fetcher,
+// and archiving every request it sends and every response it gets as WARC 1.1
+// records. Records are written to gzip-compressed WARC files (one gzip member
+// per record, as recommended by the WARC specifications), which are rotated once
+// they reach a given size.
+// The ID of the record containing the response for each page is kept until the
+// page is visited, so it can be saved along with the article.
+// Note that response bodies are archived as returned by Go's HTTP client, i.e.
+// after they have been decompressed if the website compressed them.
+type WARCFetcher struct {
+	fetcher     Fetcher
+	dir         string
+	prefix      string
+	maxSize     int64
+	userAgent   string
+	lock        sync.Mutex
+	file        *os.File
+	size        int64
+	serial      int
+	warcinfoID  string
+	recordsLock sync.Mutex
+	recordIDs   map[string]string
+}
+
+// NewWARCFetcher instantiates a new WARCFetcher archiving the requests and
+// responses of the given fetcher according to the given configuration. The name
+// of each WARC file starts with the given prefix.
+// Returns an error if the directory the WARC files are written to couldn't be
+// created.
+func NewWARCFetcher(cfg *config.WARCConfig, prefix string, fetcher Fetcher) (*WARCFetcher, error) {
+	if err := os.MkdirAll(cfg.Directory, 0755); err != nil {
+		return nil, err
+	}
+
+	maxSize := cfg.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultWARCMaxSize
+	}
+
+	return &WARCFetcher{
+		fetcher:   fetcher,
+		dir:       cfg.Directory,
+		prefix:    prefix,
+		maxSize:   maxSize * 1024 * 1024,
+		recordIDs: make(map[string]string),
+	}, nil
+}
+
+// Fetch implements Fetcher.Fetch
+// Fetches the resource using the underlying fetcher and archives the request
+// and its response, including if it's a redirection. Requests that failed for
+// any other reason aren't archived.
+// Returns an error if fetching the resource or archiving it failed.
+func (f *WARCFetcher) Fetch(u *url.URL, userAgent string, headRequest bool) (*http.Response, error) {
+	res, fetchErr := f.fetcher.Fetch(u, userAgent, headRequest)
+	if res == nil || (fetchErr != nil && !isEnqueueRedirect(fetchErr)) {
+		return res, fetchErr
+	}
+
+	// Read the body so we can both archive it and give it back to gocrawl. The
+	// body of redirections has already been closed by the HTTP client.
+	var body []byte
+	var err error
+	if fetchErr == nil {
+		body, err = ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	responseID, err := f.archive(res, body, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remember the ID of the response's record for pages that will be visited.
+	if !headRequest && !isRobotsURL(u) && res.StatusCode >= 200 && res.StatusCode < 300 {
+		f.recordsLock.Lock()
+		f.recordIDs[u.String()] = responseID
+		f.recordsLock.Unlock()
+	}
+
+	return res, fetchErr
+}
+
+// ResponseRecordID returns the ID of the WARC record containing the response
+// to the request for the page at the given URL, and forgets it.
+// Returns an empty string if no response to a request for this page has been
+// archived.
+func (f *WARCFetcher) ResponseRecordID(u string) string {
+	f.recordsLock.Lock()
+	defer f.recordsLock.Unlock()
+
+	id := f.recordIDs[u]
+	delete(f.recordIDs, u)
+	return id
+}
+
+// Close implements io.Closer.Close
+// Closes the WARC file currently being written.
+// Returns an error if closing the file failed.
+func (f *WARCFetcher) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// archive writes a request record and a response record for the given response
+// in the current WARC file, rotating it first if needed. Both records are
+// written in the same file.
+// Returns the ID of the response record, or an error if the request or the
+// response couldn't be serialised, or if writing the records failed.
+func (f *WARCFetcher) archive(res *http.Response, body []byte, userAgent string) (string, error) {
+	// Serialise the request and the response as they were sent on the wire.
+	// DumpRequestOut fills in the headers Go's HTTP client adds when sending a
+	// request, and DumpResponse gives the response's body back once it has read
+	// it.
+	request, err := httputil.DumpRequestOut(res.Request, false)
+	if err != nil {
+		return "", err
+	}
+	response, err := httputil.DumpResponse(res, true)
+	if err != nil {
+		return "", err
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.userAgent == "" {
+		f.userAgent = userAgent
+	}
+
+	if f.file == nil || f.size >= f.maxSize {
+		if err = f.rotate(); err != nil {
+			return "", err
+		}
+	}
+
+	date := time.Now().UTC().Format(warcDateFormat)
+	target := res.Request.URL.String()
+	responseID := newRecordID()
+
+	if err = f.writeRecord(warcHeaders{
+		{"WARC-Type", "response"},
+		{"WARC-Record-ID", responseID},
+		{"WARC-Warcinfo-ID", f.warcinfoID},
+		{"WARC-Date", date},
+		{"WARC-Target-URI", target},
+		{"WARC-Payload-Digest", warcDigest(body)},
+		{"Content-Type", "application/http;msgtype=response"},
+	}, response); err != nil {
+		return "", err
+	}
+
+	if err = f.writeRecord(warcHeaders{
+		{"WARC-Type", "request"},
+		{"WARC-Record-ID", newRecordID()},
+		{"WARC-Warcinfo-ID", f.warcinfoID},
+		{"WARC-Concurrent-To", responseID},
+		{"WARC-Date", date},
+		{"WARC-Target-URI", target},
+		{"Content-Type", "application/http;msgtype=request"},
+	}, request); err != nil {
+		return "", err
+	}
+
+	return responseID, nil
+}
+
+// rotate closes the current WARC file (if any) and opens a new one, starting it
+// with a warcinfo record describing the crawler. Must be called with the
+// fetcher's lock held.
+// Returns an error if closing the current file, creating the new one, or writing
+// the warcinfo record failed.
+func (f *WARCFetcher) rotate() (err error) {
+	if f.file != nil {
+		if err = f.file.Close(); err != nil {
+			return
+		}
+	}
+
+	f.serial++
+	name := fmt.Sprintf(
+		"%s-%s-%05d.warc.gz", f.prefix, time.Now().UTC().Format("20060102150405"), f.serial,
+	)
+	if f.file, err = os.OpenFile(
+		filepath.Join(f.dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644,
+	); err != nil {
+		return
+	}
+	f.size = 0
+
+	f.warcinfoID = newRecordID()
+	info := fmt.Sprintf(
+		"software: Informo crawler\r\nformat: WARC File Format 1.1\r\n"+
+			"conformsTo: http://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/\r\n"+
+			"robots: obey\r\nhttp-header-user-agent: %s\r\n",
+		f.userAgent,
+	)
+
+	return f.writeRecord(warcHeaders{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Record-ID", f.warcinfoID},
+		{"WARC-Date", time.Now().UTC().Format(warcDateFormat)},
+		{"WARC-Filename", name},
+		{"Content-Type", "application/warc-fields"},
+	}, []byte(info))
+}
+
+// writeRecord writes a WARC record with the given headers and block as a new
+// gzip member of the current WARC file. The WARC-Block-Digest and Content-Length
+// headers are computed from the block. Must be called with the fetcher's lock
+// held.
+// Returns an error if writing the record failed.
+func (f *WARCFetcher) writeRecord(headers warcHeaders, block []byte) error {
+	var buf bytes.Buffer
+	buf.WriteString("WARC/1.1\r\n")
+	for _, h := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", h[0], h[1])
+	}
+	fmt.Fprintf(&buf, "WARC-Block-Digest: %s\r\n", warcDigest(block))
+	fmt.Fprintf(&buf, "Content-Length: %d\r\n\r\n", len(block))
+	buf.Write(block)
+	buf.WriteString("\r\n\r\n")
+
+	w := &countingWriter{w: f.file}
+	gz := gzip.NewWriter(w)
+	if _, err := buf.WriteTo(gz); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	f.size += w.n
+	return nil
+}
+
+// warcHeaders represents the headers of a WARC record, as an ordered list of
+// name and value pairs.
+type warcHeaders [][2]string
+
+// countingWriter is an io.Writer counting the bytes written to an underlying
+// io.Writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements io.Writer.Write
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// newRecordID generates a new random WARC record ID, using a version 4 UUID.
+func newRecordID() string {
+	var uuid [16]byte
+	// crypto/rand.Read only fails if the system's random number generator is
+	// broken, in which case there's not much we can do.
+	rand.Read(uuid[:])
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf(
+		"<urn:uuid:%x-%x-%x-%x-%x>", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16],
+	)
+}
+
+// warcDigest computes the SHA-1 digest of the given data, in the format used by
+// the WARC-Block-Digest and WARC-Payload-Digest headers.
+func warcDigest(data []byte) string {
+	hash := sha1.Sum(data)
+	return "sha1:" + base32.StdEncoding.EncodeToString(hash[:])
+}
@@ -18,27 +18,54 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net/http"
+	"os"
 	"sync"
+	"time"
 
 	"common"
 	"common/config"
 	"common/database"
+	"common/metrics"
 	"informo-crawler/crawler"
 
 	"github.com/sirupsen/logrus"
 )
 
+// dateFlagLayout is the layout of the dates given on the command line.
+const dateFlagLayout = "2006-01-02"
+
 var (
-	configFile = flag.String("config", "config.yaml", "Configuration file")
-	debug      = flag.Bool("debug", false, "Print debugging messages")
+	configFile  = flag.String("config", "config.yaml", "Configuration file")
+	debug       = flag.Bool("debug", false, "Print debugging messages")
+	checkOnly   = flag.Bool("check-config", false, "Check the configuration file, report every problem found in it, and exit")
+	recordDir   = flag.String("record", "", "Directory to record every response in")
+	replayDir   = flag.String("replay", "", "Directory to replay recorded responses from, instead of sending requests")
+	incremental = flag.Bool("incremental", false, "Only look for new articles, and stop crawling a website once the links found only lead to known ones")
+	backfill    = flag.Bool("backfill", false, "Crawl the archive pages of the websites for the dates between -since and -until")
+	since       = flag.String("since", "", "First date (YYYY-MM-DD) of the archives to crawl in backfill mode")
+	until       = flag.String("until", "", "Last date (YYYY-MM-DD) of the archives to crawl in backfill mode (defaults to today)")
+	reportFile  = flag.String("report", "", "File to write the end-of-run report to, as JSON")
+	metricsAddr = flag.String("metrics-listen", "", "Address (e.g. 127.0.0.1:9100) to serve Prometheus metrics at while crawling")
 )
 
 func main() {
+	// Run the selector test harness instead of crawling if requested.
+	if len(os.Args) > 1 && os.Args[1] == testSelectorsCommand {
+		testSelectors(os.Args[2:])
+		return
+	}
+
 	// Parse the command line arguments.
 	flag.Parse()
 
-	// Configure the logger.
-	common.LogConfig(*debug)
+	// Only check the configuration file if requested.
+	if *checkOnly {
+		os.Exit(common.CheckConfig(*configFile, false))
+	}
+
+	// Configure the logger until the configuration is loaded.
+	common.LogConfig(nil, *debug)
 
 	// Load the configuration from the provided configuration file.
 	cfg, err := config.Load(*configFile)
@@ -46,20 +73,64 @@ func main() {
 		logrus.Panic(fmt.Errorf("Couldn't load config: %s", err.Error()))
 	}
 
+	// Configure the logger according to the configuration.
+	if err = common.LogConfig(&cfg.Logging, *debug); err != nil {
+		logrus.Panic(fmt.Errorf("Couldn't configure logs: %s", err.Error()))
+	}
+
+	// Record or replay the responses if required.
+	if len(*recordDir) > 0 && len(*replayDir) > 0 {
+		logrus.Panic(fmt.Errorf("Responses can't be recorded and replayed at the same time"))
+	}
+	cfg.Crawler.RecordDir = *recordDir
+	cfg.Crawler.ReplayDir = *replayDir
+	cfg.Crawler.Incremental = *incremental
+	if *backfill {
+		if *incremental {
+			logrus.Panic(fmt.Errorf("The incremental and backfill modes can't be used at the same time"))
+		}
+		if cfg.Crawler.Backfill, err = parseBackfillRange(*since, *until); err != nil {
+			logrus.Panic(fmt.Errorf("Invalid backfill range: %s", err.Error()))
+		}
+	}
+
 	// Open the database and prepare the required statements.
 	db, err := database.NewDatabase(cfg.Database)
 	if err != nil {
 		logrus.Panic(fmt.Errorf("Couldn't open database: %s", err.Error()))
 	}
 
+	// Serve the metrics if required.
+	if len(*metricsAddr) > 0 {
+		go serveMetrics(*metricsAddr)
+	}
+
+	// Only the websites with archive pages can be crawled in backfill mode.
+	websites := cfg.Websites
+	if cfg.Crawler.Backfill != nil {
+		websites = nil
+		for _, w := range cfg.Websites {
+			if w.Backfill != nil {
+				websites = append(websites, w)
+			} else {
+				logrus.WithField("website", w.Identifier).Info("No archive URL configured, skipping website")
+			}
+		}
+	}
+
 	// Using a sync.WaitGroup to keep track of the goroutines and only exit when
 	// all goroutines have returned.
 	var wg sync.WaitGroup
 	// Storing the crawlers in a slice outside of the loop and the goroutines in
 	// case we need to use it later.
-	crawlers := make([]*crawler.Crawler, len(cfg.Websites))
+	crawlers := make([]*crawler.Crawler, len(websites))
+	// Limit the number of websites crawled at the same time if required.
+	var slots chan struct{}
+	if cfg.Crawler.MaxConcurrentWebsites > 0 {
+		slots = make(chan struct{}, cfg.Crawler.MaxConcurrentWebsites)
+	}
 	// Spawn a crawler for each website.
-	for i, w := range cfg.Websites {
+	for i, w := range websites {
 		// Instantiate the crawler.
 		crawlers[i], err = crawler.NewCrawler(cfg.Crawler, db, w)
 		if err != nil {
@@ -73,6 +144,13 @@ func main() {
 
 		// Run the crawler in a separate goroutine.
 		go func(c *crawler.Crawler) {
+			// Wait for a slot to be available, and free it once the crawl is
+			// over.
+			if slots != nil {
+				slots <- struct{}{}
+				defer func() { <-slots }()
+			}
+
 			// Run the crawler and, when it stops, retrieve the reason that made
 			// it stop if there's one.
 			stopReason := c.Run()
@@ -91,4 +169,62 @@ func main() {
 
 	// Wait for all goroutines to end before exiting.
 	wg.Wait()
+
+	// Report on the run, and exit with a non-zero code if crawling a website
+	// failed.
+	reports := make([]*crawler.Report, len(crawlers))
+	for i, c := range crawlers {
+		reports[i] = c.Report()
+	}
+	printReport(os.Stdout, reports)
+	if len(*reportFile) > 0 {
+		if err = writeReport(*reportFile, reports); err != nil {
+			logrus.Error(fmt.Errorf("Couldn't write report: %v", err))
+			os.Exit(exitReportFailed)
+		}
+	}
+	for _, r := range reports {
+		if r.Failed {
+			os.Exit(exitWebsiteFailed)
+		}
+	}
+}
+
+// parseBackfillRange parses the dates of a backfill range, the last one
+// defaulting to the current day if empty.
+// Returns an error if a date couldn't be parsed, if the first date is missing,
+// or if it is after the last one.
+func parseBackfillRange(since string, until string) (r *config.BackfillRange, err error) {
+	if len(since) == 0 {
+		return nil, fmt.Errorf("Missing first date (-since)")
+	}
+
+	r = &config.BackfillRange{Until: time.Now()}
+	if r.Since, err = time.Parse(dateFlagLayout, since); err != nil {
+		return nil, err
+	}
+	if len(until) > 0 {
+		if r.Until, err = time.Parse(dateFlagLayout, until); err != nil {
+			return nil, err
+		}
+	}
+	if r.Since.After(r.Until) {
+		return nil, fmt.Errorf("%s is after %s", since, r.Until.Format(dateFlagLayout))
+	}
+
+	return
+}
+
+// serveMetrics starts a web server serving the crawler's metrics at /metrics on
+// the given address. Since the metrics aren't required for the crawl to
+// happen, the crawl isn't stopped if the server fails, only the error is
+// logged.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+
+	logrus.Info("Serving metrics on " + addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logrus.Error(fmt.Errorf("Couldn't serve metrics: %v", err))
+	}
 }
@@ -0,0 +1,76 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"text/tabwriter"
+
+	"informo-crawler/crawler"
+)
+
+// Exit codes of the crawler, other than 0 (every website was crawled
+// successfully).
+const (
+	// exitWebsiteFailed means crawling at least one website failed (see
+	// crawler.Report).
+	exitWebsiteFailed = 1
+	// exitReportFailed means the report couldn't be written.
+	exitReportFailed = 3
+)
+
+// printReport writes the end-of-run report as a human-readable table, with one
+// line per website, followed by the reason each failed website failed for.
+func printReport(w io.Writer, reports []*crawler.Report) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "WEBSITE\tFETCHED\tVISITED\tSAVED\tKNOWN\tFILTERED\tSEEN\tUNCHANGED\tHTTP ERRORS\tDATE ERRORS\tDURATION\tSTATUS\t")
+	for _, r := range reports {
+		status := "ok"
+		if r.Failed {
+			status = "FAILED"
+		}
+
+		fmt.Fprintf(
+			tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%.1fs\t%s\t\n",
+			r.Website, r.PagesFetched, r.PagesVisited, r.ArticlesSaved, r.SkippedKnown,
+			r.FilteredOut, r.SkippedSeen, r.NotModified, r.HTTPErrors, r.DateParseFailures, r.Duration, status,
+		)
+	}
+	tw.Flush()
+
+	for _, r := range reports {
+		if r.Failed {
+			fmt.Fprintf(w, "%s failed: %s\n", r.Website, r.Reason)
+		}
+	}
+}
+
+// writeReport writes the end-of-run report as JSON to the file at the given
+// path.
+// Returns an error if the report couldn't be serialised or written.
+func writeReport(path string, reports []*crawler.Report) error {
+	content, err := json.MarshalIndent(struct {
+		Websites []*crawler.Report `json:"websites"`
+	}{reports}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(content, '\n'), 0644)
+}
@@ -0,0 +1,214 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"common"
+	"common/config"
+	"informo-crawler/crawler"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/sirupsen/logrus"
+)
+
+// testSelectorsCommand is the name of the subcommand testing a website's
+// selectors on a single page.
+const testSelectorsCommand = "test-selectors"
+
+// testSelectors runs the extraction logic used when crawling a website on a
+// single page, which is either fetched from the website or read from a local
+// HTML file, and prints the result of the extraction. Nothing is saved in the
+// database.
+// Exits with a non-zero code if the page isn't recognised as an article, or if
+// errors happened during the extraction.
+func testSelectors(args []string) {
+	flags := flag.NewFlagSet(testSelectorsCommand, flag.ExitOnError)
+	configFile := flags.String("config", "config.yaml", "Configuration file")
+	identifier := flags.String("website", "", "Identifier of the website which selectors to test")
+	pageURL := flags.String("url", "", "URL of the page to test the selectors on")
+	htmlFile := flags.String("file", "", "Local HTML file to test the selectors on, instead of fetching the page (-url is then used to resolve relative links)")
+	debug := flags.Bool("debug", false, "Print debugging messages")
+	flags.Parse(args)
+
+	common.LogConfig(nil, *debug)
+
+	if len(*identifier) == 0 || (len(*pageURL) == 0 && len(*htmlFile) == 0) {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s -website IDENTIFIER (-url URL | -file PATH)\n", filepath.Base(os.Args[0]), testSelectorsCommand)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+
+	// Load the configuration from the provided configuration file.
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		logrus.Panic(fmt.Errorf("Couldn't load config: %s", err.Error()))
+	}
+
+	// Configure the logger according to the configuration.
+	if err = common.LogConfig(&cfg.Logging, *debug); err != nil {
+		logrus.Panic(fmt.Errorf("Couldn't configure logs: %s", err.Error()))
+	}
+
+	// Look for the website in the configuration.
+	var website *config.Website
+	for _, w := range cfg.Websites {
+		if w.Identifier == *identifier {
+			website = w
+		}
+	}
+	if website == nil {
+		logrus.Panic(fmt.Errorf("Unknown website %s", *identifier))
+	}
+
+	// Relative links are resolved using the page's URL, or the website's start
+	// point if no URL was provided.
+	u, err := url.Parse(website.StartPoint)
+	if len(*pageURL) > 0 {
+		u, err = url.Parse(*pageURL)
+	}
+	if err != nil {
+		logrus.Panic(fmt.Errorf("Invalid URL: %s", err.Error()))
+	}
+
+	// Load the page.
+	var doc *goquery.Document
+	if len(*htmlFile) > 0 {
+		doc, err = loadLocalPage(*htmlFile)
+	} else {
+		doc, err = fetchPage(cfg.Crawler, website, u)
+	}
+	if err != nil {
+		logrus.Panic(fmt.Errorf("Couldn't load page: %s", err.Error()))
+	}
+	doc.Url = u
+
+	extraction := crawler.Extract(website, doc, u)
+	printExtraction(extraction)
+
+	if extraction.Article == nil || len(extraction.Errors) > 0 {
+		os.Exit(1)
+	}
+}
+
+// loadLocalPage reads and parses a local HTML file.
+// Returns an error if the file couldn't be read or parsed.
+func loadLocalPage(path string) (*goquery.Document, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return goquery.NewDocumentFromReader(file)
+}
+
+// fetchPage fetches a page using the same session and fetcher as the crawler
+// would, except its responses aren't archived and the session's cookies aren't
+// saved, and parses it.
+// Returns an error if logging in to the website failed, if the page couldn't be
+// fetched or parsed, or if the website didn't respond with a 2xx status code.
+func fetchPage(
+	cfg config.CrawlerConfig, website *config.Website, u *url.URL,
+) (*goquery.Document, error) {
+	w := *website
+	w.WARC = nil
+
+	session, err := crawler.NewSession(cfg, &w, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = session.Login(cfg.UserAgent); err != nil {
+		return nil, fmt.Errorf("Login failed: %v", err)
+	}
+
+	fetcher, err := crawler.NewFetcher(cfg, &w, session)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := fetcher.Fetch(u, cfg.UserAgent, false)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("Website responded with status %s", res.Status)
+	}
+
+	return goquery.NewDocumentFromResponse(res)
+}
+
+// printExtraction prints the result of an extraction in a human-readable way.
+func printExtraction(extraction *crawler.Extraction) {
+	fmt.Println("Selector matches:")
+	names := make([]string, 0, len(extraction.Matches))
+	for name := range extraction.Matches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %-12s %d\n", name+":", extraction.Matches[name])
+	}
+	fmt.Println()
+
+	if len(extraction.Errors) > 0 {
+		fmt.Println("Errors:")
+		for _, err := range extraction.Errors {
+			fmt.Printf("  %s\n", err.Error())
+		}
+		fmt.Println()
+	}
+
+	a := extraction.Article
+	if a == nil {
+		fmt.Println("This page isn't an article: the title and content selectors must match")
+		fmt.Println("exactly one node each, and the date selector at least one node (unless")
+		fmt.Println("the date can be read from the URL).")
+		return
+	}
+
+	fmt.Printf("URL:          %s\n", a.URL)
+	fmt.Printf("Title:        %s\n", a.Title)
+	fmt.Printf("Description:  %s\n", optionalString(a.Description))
+	fmt.Printf("Author:       %s\n", optionalString(a.Author))
+	fmt.Printf("Date (raw):   %s\n", extraction.RawDate)
+	if extraction.DateFromURL {
+		fmt.Printf("Date:         %s (read from the URL)\n", a.Date.String())
+	} else {
+		fmt.Printf("Date:         %s\n", a.Date.String())
+	}
+	fmt.Printf("Language:     %s\n", optionalString(a.Language))
+	fmt.Println()
+	fmt.Println("Content:")
+	fmt.Println(a.Content)
+}
+
+// optionalString returns the value of an optional string, or a placeholder if
+// it's nil.
+func optionalString(str *string) string {
+	if str == nil {
+		return "(none)"
+	}
+	return *str
+}
@@ -0,0 +1,111 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package generator
+
+import (
+	"sync"
+	"time"
+
+	"common/metrics"
+)
+
+// Metrics exposed by the generator.
+var (
+	feedsServedMetric = metrics.NewCounter(
+		"informo_generator_feeds_served_total",
+		"Number of feeds served.",
+		"website",
+	)
+	feedLatencyMetric = metrics.NewHistogram(
+		"informo_generator_feed_latency_seconds",
+		"Time taken to serve a feed, in seconds.",
+		nil, "website",
+	)
+	cacheHitsMetric = metrics.NewCounter(
+		"informo_generator_cache_hits_total",
+		"Number of feeds served from the cache.",
+		"website",
+	)
+	cacheMissesMetric = metrics.NewCounter(
+		"informo_generator_cache_misses_total",
+		"Number of feeds that weren't in the cache and had to be generated.",
+		"website",
+	)
+	dbErrorsMetric = metrics.NewCounter(
+		"informo_generator_db_errors_total",
+		"Number of errors that happened when retrieving articles from the database.",
+		"website",
+	)
+)
+
+// feedCache keeps generated feeds in memory for a given time, so they don't
+// have to be generated again for every request. It is safe to use from several
+// goroutines. A cache with a zero TTL is disabled.
+type feedCache struct {
+	ttl     time.Duration
+	lock    sync.Mutex
+	entries map[string]cachedFeed
+}
+
+// cachedFeed represents a feed kept in the cache, along with the time after
+// which it shouldn't be served anymore.
+type cachedFeed struct {
+	feed    string
+	expires time.Time
+}
+
+// newFeedCache instantiates a new feedCache keeping feeds for the given time.
+func newFeedCache(ttl time.Duration) *feedCache {
+	return &feedCache{
+		ttl:     ttl,
+		entries: make(map[string]cachedFeed),
+	}
+}
+
+// enabled checks whether feeds are kept in the cache.
+func (c *feedCache) enabled() bool {
+	return c.ttl > 0
+}
+
+// get retrieves the feed stored with the given key, if it hasn't expired.
+// Returns false if no such feed is in the cache.
+func (c *feedCache) get(key string) (string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return "", false
+	}
+
+	return entry.feed, true
+}
+
+// set stores a feed with the given key, if the cache is enabled.
+func (c *feedCache) set(key string, feed string) {
+	if !c.enabled() {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries[key] = cachedFeed{feed: feed, expires: time.Now().Add(c.ttl)}
+}
@@ -17,40 +17,75 @@ package generator
 
 import (
 	"fmt"
+	"html"
 	"net/http"
 	"net/url"
+	"sort"
+	"strings"
+	"time"
 
 	"common"
 	"common/config"
 	"common/database"
+	"common/metrics"
 
 	"github.com/gorilla/feeds"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 )
 
+// unknownFeedLabel is the label used in the metrics for the requests to feeds
+// that aren't in the configuration, so that requests to random paths don't
+// create new series.
+const unknownFeedLabel = "unknown"
+
 // Generator represents a RSS generator.
 type Generator struct {
-	db  *database.Database
-	mux *mux.Router
-	cfg *config.FeedsConfig
+	db    *database.Database
+	mux   *mux.Router
+	cfg   *config.FeedsConfig
+	cache *feedCache
+	// feeds contains the names of the feeds that can be served, i.e. the
+	// identifiers of the websites and the names of the aggregate feeds.
+	feeds map[string]bool
 }
 
-// NewGenerator instantiate a new Generator.
-func NewGenerator(db *database.Database, cfg *config.FeedsConfig) *Generator {
+// NewGenerator instantiate a new Generator serving the feeds of the given
+// websites, along with the aggregate feeds from the configuration.
+func NewGenerator(db *database.Database, cfg *config.FeedsConfig, websites []*config.Website) *Generator {
+	feeds := make(map[string]bool)
+	for _, website := range websites {
+		feeds[website.Identifier] = true
+	}
+	for name := range cfg.Aggregates {
+		feeds[name] = true
+	}
+
 	return &Generator{
-		db:  db,
-		mux: mux.NewRouter(),
-		cfg: cfg,
+		db:    db,
+		mux:   mux.NewRouter(),
+		cfg:   cfg,
+		cache: newFeedCache(cfg.CacheTTL * time.Second),
+		feeds: feeds,
 	}
 }
 
+// metricsLabel returns the label identifying a feed in the metrics, which is
+// the feed's name if it is in the configuration, or unknownFeedLabel if not.
+func (g *Generator) metricsLabel(feed string) string {
+	if g.feeds[feed] {
+		return feed
+	}
+
+	return unknownFeedLabel
+}
+
 // SetupAndServe sets up the Generator's router and starts a web server that
 // serves it at a given interface and port.
 func (g *Generator) SetupAndServe() error {
 	g.setup()
 	listenAddr := fmt.Sprintf("%s:%d", g.cfg.Interface, g.cfg.Port)
-	logrus.Info("Starting Web server, listening on " + listenAddr)
+	common.Logger("generator").Info("Starting Web server, listening on " + listenAddr)
 	return http.ListenAndServe(listenAddr, g.mux)
 }
 
@@ -60,11 +95,22 @@ func (g *Generator) SetupAndServe() error {
 // If the handler function encounters an error, it will log send the string
 // "Internal server error" to the requester, log the error's message and return,
 // thus aborting the process.
+// If caching is enabled, feeds are served from the cache when possible.
+// Also defines the routes of the status API (see serveStatus) and of the
+// metrics.
 func (g *Generator) setup() {
 	// This string will be sent as a response to the request if any error happens
 	// in order to avoid sending sensitive data contained in the error's message.
 	var intSrvErr = "Internal server error"
 
+	// Serve the extraction statistics of the crawler's runs.
+	g.mux.HandleFunc("/api/status", g.serveStatus)
+	g.mux.HandleFunc("/api/status/{website}", g.serveStatus)
+
+	// Serve the metrics. This needs to be defined before the feeds' route, so
+	// it isn't considered as a website.
+	g.mux.Handle("/metrics", metrics.Handler())
+
 	// Define a global route that will take the website's name as the only element
 	// that can follow the initial "/".
 	g.mux.HandleFunc("/{website}", func(w http.ResponseWriter, req *http.Request) {
@@ -72,16 +118,49 @@ func (g *Generator) setup() {
 		vars := mux.Vars(req)
 		// Define a logger specificly for logging errors since we need to call it
 		// from several places in this function.
-		errLog := logrus.WithField("website", vars["website"])
+		errLog := common.Logger("generator").WithFields(logrus.Fields{
+			"website": vars["website"],
+			"url":     req.URL.String(),
+		})
+		label := g.metricsLabel(vars["website"])
+		start := time.Now()
+
+		// Normalise the requested language (e.g. "fr-FR" or "FR") the same way
+		// the crawler does before storing articles' languages.
+		rawLang := req.URL.Query().Get("lang")
+		lang := common.NormaliseLanguageTag(rawLang)
+		if len(rawLang) > 0 && len(lang) == 0 {
+			http.Error(w, fmt.Sprintf("Invalid language %s", rawLang), 400)
+			return
+		}
+
+		// Serve the feed from the cache if it's there.
+		cacheKey := vars["website"] + "?lang=" + lang
+		if g.cache.enabled() {
+			if feedStr, ok := g.cache.get(cacheKey); ok {
+				cacheHitsMetric.Inc(label)
+				g.serveFeed(w, label, feedStr, start)
+				return
+			}
+			cacheMissesMetric.Inc(label)
+		}
 
-		// Get the n latest articles for the requested website, n being a number
-		// set in the configuration file.
-		articles, err := g.db.RetrieveNLatestArticlesForWebsite(
-			vars["website"], g.cfg.NbItems,
-		)
+		// Get the n latest articles for the requested website, or from all the
+		// websites of the requested aggregate feed, n being a number set in the
+		// configuration file. If a language is provided in the query string
+		// (e.g. "?lang=fr"), only retrieve articles written in it.
+		var articles []common.Article
+		var err error
+		websites, isAggregate := g.cfg.Aggregates[vars["website"]]
+		if isAggregate {
+			articles, err = g.retrieveAggregateArticles(websites, lang)
+		} else {
+			articles, err = g.retrieveArticles(vars["website"], lang)
+		}
 		if err != nil {
 			http.Error(w, intSrvErr, 500)
 			errLog.Error(err)
+			dbErrorsMetric.Inc(label)
 			return
 		}
 
@@ -93,17 +172,32 @@ func (g *Generator) setup() {
 		}
 
 		// Generate the gorilla/feeds representation of the feed we want to generate
-		// with these articles.
-		feed, err := g.getFeed(articles, vars["website"])
+		// with these articles. Aggregate feeds gather articles from several
+		// websites, so they link to themselves rather than to one of them.
+		var link string
+		if isAggregate {
+			scheme := "http"
+			if req.TLS != nil {
+				scheme = "https"
+			}
+			link = fmt.Sprintf("%s://%s/%s", scheme, req.Host, vars["website"])
+		}
+		feed, err := g.getFeed(articles, vars["website"], link)
 		if err != nil {
 			http.Error(w, intSrvErr, 500)
 			errLog.Error(err)
 			return
 		}
 
+		// If no language was requested, use the articles' language if they all
+		// share the same one.
+		if len(lang) == 0 {
+			lang = commonLanguage(articles)
+		}
+
 		// Convert this feed to string accordingly with the FeedType configuration
 		// setting.
-		feedStr, err := g.feedToString(feed)
+		feedStr, err := g.feedToString(feed, lang)
 		if err != nil {
 			http.Error(w, intSrvErr, 500)
 			errLog.Error(err)
@@ -111,36 +205,138 @@ func (g *Generator) setup() {
 		}
 
 		// Log each feed generation.
-		logrus.WithFields(logrus.Fields{
+		common.Logger("generator").WithFields(logrus.Fields{
 			"website":        vars["website"],
+			"url":            req.URL.String(),
 			"content_length": len(feedStr),
 			"feed_type":      g.cfg.Type,
 			"nb_items":       g.cfg.NbItems,
-		}).Info("Served feed")
+			"language":       lang,
+		}).Info("Generated feed")
 
-		w.Header().Add("Content-Type", "text/xml;charset=utf-8")
-		// Serve the feed.
-		w.Write([]byte(feedStr))
+		g.cache.set(cacheKey, feedStr)
+		g.serveFeed(w, label, feedStr, start)
 	})
 }
 
+// retrieveArticles retrieves the n latest articles from a website, n being the
+// number of items in a feed. If a language is provided, only retrieves articles
+// written in it.
+// Returns an error if the retrieval failed.
+func (g *Generator) retrieveArticles(website string, lang string) ([]common.Article, error) {
+	if len(lang) > 0 {
+		return g.db.RetrieveNLatestArticlesForWebsiteInLanguage(website, lang, g.cfg.NbItems)
+	}
+
+	return g.db.RetrieveNLatestArticlesForWebsite(website, g.cfg.NbItems)
+}
+
+// retrieveAggregateArticles retrieves the n latest articles from a set of
+// websites, n being the number of items in a feed, in counter-chronological
+// order. If a language is provided, only retrieves articles written in it. If
+// duplicates are collapsed, only one article of each group of near-duplicates
+// is kept (see collapseDuplicates).
+// Returns an error if the retrieval failed.
+func (g *Generator) retrieveAggregateArticles(websites []string, lang string) ([]common.Article, error) {
+	var articles []common.Article
+	for _, website := range websites {
+		latest, err := g.retrieveArticles(website, lang)
+		if err != nil {
+			return nil, err
+		}
+		articles = append(articles, latest...)
+	}
+
+	sort.SliceStable(articles, func(i, j int) bool {
+		return articles[i].Date.After(articles[j].Date)
+	})
+
+	if g.cfg.CollapseDuplicates {
+		var err error
+		if articles, err = g.collapseDuplicates(articles); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(articles) > g.cfg.NbItems {
+		articles = articles[:g.cfg.NbItems]
+	}
+
+	return articles, nil
+}
+
+// collapseDuplicates removes from a slice of articles the near-duplicates of
+// the articles that precede them, and appends to the content of the articles
+// that are kept links to their near-duplicates, so readers can still pick the
+// website they prefer to read it on.
+// Returns an error if the near-duplicates of an article couldn't be retrieved.
+func (g *Generator) collapseDuplicates(articles []common.Article) ([]common.Article, error) {
+	var collapsed []common.Article
+	alternatives := make(map[string]bool)
+	for _, a := range articles {
+		if alternatives[a.URL] {
+			continue
+		}
+
+		urls, err := g.db.RetrieveDuplicates(a.URL)
+		if err != nil {
+			return nil, err
+		}
+		if len(urls) > 0 {
+			var links strings.Builder
+			for _, u := range urls {
+				alternatives[u] = true
+				name := u
+				if parsed, err := url.Parse(u); err == nil {
+					name = parsed.Host
+				}
+				fmt.Fprintf(
+					&links, "<li><a href=\"%s\">%s</a></li>",
+					html.EscapeString(u), html.EscapeString(name),
+				)
+			}
+			a.Content += "<p>Also published at:</p><ul>" + links.String() + "</ul>"
+		}
+
+		collapsed = append(collapsed, a)
+	}
+
+	return collapsed, nil
+}
+
+// serveFeed sends a feed to the requester, and updates the metrics identified
+// by the given label (see metricsLabel) with the time it took to serve it since
+// the request was received.
+func (g *Generator) serveFeed(w http.ResponseWriter, label string, feedStr string, start time.Time) {
+	w.Header().Add("Content-Type", "text/xml;charset=utf-8")
+	// Serve the feed.
+	w.Write([]byte(feedStr))
+
+	feedsServedMetric.Inc(label)
+	feedLatencyMetric.Observe(time.Since(start).Seconds(), label)
+}
+
 // getFeed generates a gorilla/feeds representation of a feed using the given articles
-// and information about the site.
+// and information about the site. If no link is provided, the feed links to the
+// website's base URL (scheme://host).
 // Returns an error if there was an issue parsing a URL to get the website's base
-// URL (scheme://host).
-func (g *Generator) getFeed(articles []common.Article, website string) (*feeds.Feed, error) {
-	// If this function is called, it means there's at least one article in the
-	// slice. Because all articles supposedly come from the same website, we can
-	// just take the first one to extract its scheme and host.
-	u, err := url.Parse(articles[0].URL)
-	if err != nil {
-		return nil, err
+// URL.
+func (g *Generator) getFeed(articles []common.Article, website string, link string) (*feeds.Feed, error) {
+	if len(link) == 0 {
+		// If this function is called, it means there's at least one article in
+		// the slice. Because all articles supposedly come from the same website,
+		// we can just take the first one to extract its scheme and host.
+		u, err := url.Parse(articles[0].URL)
+		if err != nil {
+			return nil, err
+		}
+		link = fmt.Sprintf("%s://%s", u.Scheme, u.Host)
 	}
 
 	// Allocate the feed structure.
 	feed := &feeds.Feed{
 		Title: website,
-		Link:  &feeds.Link{Href: fmt.Sprintf("%s://%s", u.Scheme, u.Host)},
+		Link:  &feeds.Link{Href: link},
 	}
 
 	// Will serve as a buffer between the filling of the item and its appending
@@ -173,14 +369,48 @@ func (g *Generator) getFeed(articles []common.Article, website string) (*feeds.F
 
 // feedToString generate the XML string from the given gorilla/feeds representation
 // of the feed, accordingly with the FeedType specified in the configuration file,
-// which should refer to either a RSS feed or an Atom feed.
+// which should refer to either a RSS feed or an Atom feed. If a language is
+// provided, it is set as the feed's <language> (RSS) or xml:lang (Atom).
 // Returns an error if there was an issue generating the string.
-func (g *Generator) feedToString(feed *feeds.Feed) (string, error) {
+func (g *Generator) feedToString(feed *feeds.Feed, lang string) (string, error) {
 	switch g.cfg.Type {
 	case config.FeedTypeRSS:
-		return feed.ToRss()
+		rssFeed := (&feeds.Rss{Feed: feed}).RssFeed()
+		rssFeed.Language = lang
+		return feeds.ToXML(rssFeed)
 	case config.FeedTypeAtom:
-		return feed.ToAtom()
+		return feeds.ToXML(&atomFeedWithLang{
+			AtomFeed: (&feeds.Atom{Feed: feed}).AtomFeed(),
+			Lang:     lang,
+		})
 	}
 	return "", nil
 }
+
+// atomFeedWithLang wraps a gorilla/feeds representation of an Atom feed to add
+// the xml:lang attribute to its root node, since gorilla/feeds doesn't support
+// it.
+type atomFeedWithLang struct {
+	*feeds.AtomFeed
+	Lang string `xml:"http://www.w3.org/XML/1998/namespace lang,attr,omitempty"`
+}
+
+// FeedXml implements feeds.XmlFeed.FeedXml
+func (a *atomFeedWithLang) FeedXml() interface{} {
+	return a
+}
+
+// commonLanguage returns the language shared by all of the given articles.
+// Returns an empty string if at least one of the articles has no language, or
+// if the articles aren't all written in the same language.
+func commonLanguage(articles []common.Article) string {
+	var lang string
+	for i, a := range articles {
+		if a.Language == nil || (i > 0 && *a.Language != lang) {
+			return ""
+		}
+		lang = *a.Language
+	}
+
+	return lang
+}
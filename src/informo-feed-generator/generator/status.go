@@ -0,0 +1,104 @@
+// Copyright 2018 Informo core team <core@informo.network>
+//
+// Licensed under the GNU Affero General Public License, Version 3.0
+// (the "License"); you may not use this file except in compliance with the
+// License.
+// You may obtain a copy of the License at
+//
+//     https://www.gnu.org/licenses/agpl-3.0.html
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"common"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// statusHistorySize is the number of runs returned when requesting the status
+// of a single website.
+const statusHistorySize = 20
+
+// runStatus is the JSON representation of a run of the crawler on a website, as
+// served by the status API.
+type runStatus struct {
+	Website             string             `json:"website"`
+	StartedAt           time.Time          `json:"started_at"`
+	EndedAt             time.Time          `json:"ended_at"`
+	PagesVisited        int                `json:"pages_visited"`
+	ArticlePagesVisited int                `json:"article_pages_visited"`
+	ArticlesSaved       int                `json:"articles_saved"`
+	DateParseFailures   int                `json:"date_parse_failures"`
+	SelectorMatchRate   map[string]float64 `json:"selector_match_rates"`
+	Degraded            bool               `json:"degraded"`
+}
+
+// newRunStatus converts the statistics of a run into their JSON representation,
+// computing the ratio of visited pages expected to be articles each selector
+// matched in.
+func newRunStatus(run common.CrawlRun) runStatus {
+	return runStatus{
+		Website:             run.Website,
+		StartedAt:           run.StartedAt,
+		EndedAt:             run.EndedAt,
+		PagesVisited:        run.PagesVisited,
+		ArticlePagesVisited: run.ArticlePagesVisited,
+		ArticlesSaved:       run.ArticlesSaved,
+		DateParseFailures:   run.DateParseFailures,
+		SelectorMatchRate:   run.MatchRates(),
+		Degraded:            run.Degraded,
+	}
+}
+
+// serveStatus handles requests to /api/status, by serving the statistics of the
+// latest run of the crawler on each website, and to /api/status/{website}, by
+// serving the statistics of the latest runs on the given website.
+// If retrieving the statistics fails, sends the string "Internal server error"
+// to the requester and logs the error's message.
+func (g *Generator) serveStatus(w http.ResponseWriter, req *http.Request) {
+	website, single := mux.Vars(req)["website"]
+	errLog := common.Logger("generator").WithFields(logrus.Fields{
+		"website": website,
+		"url":     req.URL.String(),
+	})
+
+	var runs []common.CrawlRun
+	var err error
+	if single {
+		runs, err = g.db.RetrieveNLatestCrawlRunsForWebsite(website, statusHistorySize)
+	} else {
+		runs, err = g.db.RetrieveLatestCrawlRuns()
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", 500)
+		errLog.Error(err)
+		return
+	}
+
+	if single && len(runs) == 0 {
+		http.Error(w, fmt.Sprintf("Unknown website %s", website), 404)
+		return
+	}
+
+	statuses := make([]runStatus, len(runs))
+	for i, run := range runs {
+		statuses[i] = newRunStatus(run)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(statuses); err != nil {
+		errLog.Error(err)
+	}
+}
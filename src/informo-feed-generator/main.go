@@ -18,6 +18,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 
 	"common"
 	"common/config"
@@ -30,14 +31,20 @@ import (
 var (
 	configFile = flag.String("config", "config.yaml", "Configuration file")
 	debug      = flag.Bool("debug", false, "Print debugging messages")
+	checkOnly  = flag.Bool("check-config", false, "Check the configuration file, report every problem found in it, and exit")
 )
 
 func main() {
 	// Parse the command line arguments.
 	flag.Parse()
 
-	// Configure the logger.
-	common.LogConfig(*debug)
+	// Only check the configuration file if requested.
+	if *checkOnly {
+		os.Exit(common.CheckConfig(*configFile, true))
+	}
+
+	// Configure the logger until the configuration is loaded.
+	common.LogConfig(nil, *debug)
 
 	// Load the configuration from the provided configuration file.
 	cfg, err := config.Load(*configFile)
@@ -45,6 +52,11 @@ func main() {
 		logrus.Panic(fmt.Errorf("Couldn't load config: %s", err.Error()))
 	}
 
+	// Configure the logger according to the configuration.
+	if err = common.LogConfig(&cfg.Logging, *debug); err != nil {
+		logrus.Panic(fmt.Errorf("Couldn't configure logs: %s", err.Error()))
+	}
+
 	// Check if there's a "feed" section in the configuration file.
 	if cfg.FeedsConfig == nil {
 		logrus.Panic(fmt.Errorf("No 'feeds' configuration found, please provide one"))
@@ -57,7 +69,7 @@ func main() {
 	}
 
 	// Instantiate the Generator.
-	g := generator.NewGenerator(db, cfg.FeedsConfig)
+	g := generator.NewGenerator(db, cfg.FeedsConfig, cfg.Websites)
 
 	// Serve the feeds and listen on the configured interface and port.
 	if err = g.SetupAndServe(); err != nil {